@@ -0,0 +1,73 @@
+package lockdb
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrLockNotFound = errors.New("lock not found")
+
+// List returns the rows of all exclusive locks by name, held or expired.
+func (l *DBLocker) List() ([]Lock, error) {
+	var locks []Lock
+	err := l.db.Order("name").Find(&locks).Error
+	return locks, err
+}
+
+// Describe returns the row of the exclusive lock name, or ErrLockNotFound.
+func (l *DBLocker) Describe(name string) (*Lock, error) {
+	var lock Lock
+	err := l.db.Take(&lock, "name=?", name).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrLockNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &lock, nil
+}
+
+// ForceRelease deletes the lock name whoever holds it, the holder finds it
+// lost at its next heartbeat. It is meant for operators unsticking a lock.
+func (l *DBLocker) ForceRelease(name string) error {
+	result := l.db.Where("name=?", name).Delete(&Lock{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrLockNotFound
+	}
+	notifyRelease(l.opts, name)
+	return nil
+}
+
+// ReapExpired calls ReleaseTimeout for every name held exclusively or shared
+// and returns the names whose exclusive lock was deleted.
+func (l *DBLocker) ReapExpired(heartbeatTimeout time.Duration) ([]string, error) {
+	var names, sharedNames []string
+	if err := l.db.Model(&Lock{}).Order("name").Pluck("name", &names).Error; err != nil {
+		return nil, err
+	}
+	if err := l.db.Model(&SharedLock{}).Distinct().Pluck("name", &sharedNames).Error; err != nil {
+		return nil, err
+	}
+
+	var reaped []string
+	for _, name := range names {
+		released, err := l.ReleaseTimeout(name, heartbeatTimeout)
+		if err != nil {
+			return reaped, err
+		}
+		if released {
+			reaped = append(reaped, name)
+		}
+	}
+	for _, name := range sharedNames {
+		if err := l.reapShared(l.db, name, heartbeatTimeout); err != nil {
+			return reaped, err
+		}
+	}
+	return reaped, nil
+}
@@ -0,0 +1,120 @@
+package lockdb
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff decides how long a waiter sleeps after a busy try of a lock.
+type Backoff interface {
+	// Next returns the wait after the busy try numbered attempt, counted
+	// from 0, prev is what Next returned for the previous try.
+	Next(attempt int, prev time.Duration) time.Duration
+}
+
+// FixedBackoff waits Interval after every busy try, 50ms if it is not
+// positive.
+type FixedBackoff struct {
+	Interval time.Duration
+}
+
+func (b FixedBackoff) Next(attempt int, prev time.Duration) time.Duration {
+	return orDefaultPoll(b.Interval)
+}
+
+// ExponentialBackoff waits a random time in [0, min(Max, Base*2^attempt)),
+// i.e. exponential backoff with full jitter. A Base not positive means 50ms
+// and a Max not positive means no cap.
+type ExponentialBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+func (b ExponentialBackoff) Next(attempt int, prev time.Duration) time.Duration {
+	return randDuration(0, exponential(orDefaultPoll(b.Base), b.Max, attempt))
+}
+
+// DecorrelatedJitterBackoff waits a random time in [Base, prev*3) capped by
+// Max if positive, so the waits of contenders drift apart quickly. A Base
+// not positive means 50ms.
+type DecorrelatedJitterBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+func (b DecorrelatedJitterBackoff) Next(attempt int, prev time.Duration) time.Duration {
+	base := orDefaultPoll(b.Base)
+	if prev < base {
+		prev = base
+	}
+	d := randDuration(base, prev*3)
+	if b.Max > 0 && d > b.Max {
+		d = b.Max
+	}
+	return d
+}
+
+// cappedBackoff caps the waits of a Backoff by max.
+type cappedBackoff struct {
+	Backoff
+	max time.Duration
+}
+
+func (b cappedBackoff) Next(attempt int, prev time.Duration) time.Duration {
+	if d := b.Backoff.Next(attempt, prev); d < b.max {
+		return d
+	}
+	return b.max
+}
+
+// pollBackoff is the default Backoff made of Options.PollInterval,
+// MaxPollInterval and PollJitter.
+type pollBackoff struct {
+	interval time.Duration
+	max      time.Duration
+	jitter   float64
+}
+
+func (b pollBackoff) Next(attempt int, prev time.Duration) time.Duration {
+	d := exponential(b.interval, b.max, attempt)
+	if b.jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * b.jitter * float64(d))
+	}
+	return d
+}
+
+// exponential returns base*2^attempt capped by max if positive, and by the
+// longest Duration anyway.
+func exponential(base, max time.Duration, attempt int) time.Duration {
+	d := base
+	for i := 0; i < attempt && (max <= 0 || d < max); i++ {
+		if d > math.MaxInt64/2 {
+			d = math.MaxInt64
+			break
+		}
+		d *= 2
+	}
+	if max > 0 && d > max {
+		d = max
+	}
+	return d
+}
+
+// orDefaultPoll returns d, or defaultPollInterval if d is not positive so
+// that a zero Backoff does not poll the database in a busy loop.
+func orDefaultPoll(d time.Duration) time.Duration {
+	if d <= 0 {
+		return defaultPollInterval
+	}
+	return d
+}
+
+// randDuration returns a random duration in [min, max), or min if the range
+// is empty.
+func randDuration(min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+	return min + time.Duration(rand.Int63n(int64(max-min)))
+}
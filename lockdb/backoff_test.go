@@ -0,0 +1,60 @@
+package lockdb
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestExponential(t *testing.T) {
+	for _, c := range []struct {
+		base, max time.Duration
+		attempt   int
+		want      time.Duration
+	}{
+		{time.Millisecond, time.Second, 0, time.Millisecond},
+		{time.Millisecond, time.Second, 3, time.Millisecond * 8},
+		{time.Millisecond, time.Second, 20, time.Second},
+		{time.Millisecond * 10, 0, 4, time.Millisecond * 160},
+		{time.Millisecond, 0, 100, math.MaxInt64},
+	} {
+		if got := exponential(c.base, c.max, c.attempt); got != c.want {
+			t.Errorf("exponential(%v, %v, %d) = %v, want %v", c.base, c.max, c.attempt, got, c.want)
+		}
+	}
+}
+
+func TestExponentialBackoffGrowsWithoutMax(t *testing.T) {
+	b := ExponentialBackoff{Base: time.Millisecond * 10}
+	for i := 0; i < 20; i++ {
+		if b.Next(10, 0) >= time.Millisecond*10 {
+			return
+		}
+	}
+	t.Error("ExponentialBackoff without Max does not grow past Base")
+}
+
+func TestDecorrelatedJitterBackoff(t *testing.T) {
+	b := DecorrelatedJitterBackoff{Base: time.Millisecond * 10, Max: time.Second}
+	var prev time.Duration
+	for i := 0; i < 100; i++ {
+		d := b.Next(i, prev)
+		if d < b.Base || d > b.Max {
+			t.Fatalf("wait %v is out of [%v, %v]", d, b.Base, b.Max)
+		}
+		prev = d
+	}
+}
+
+func TestZeroBackoffWaits(t *testing.T) {
+	for _, b := range []Backoff{FixedBackoff{}, ExponentialBackoff{}, DecorrelatedJitterBackoff{Max: time.Second}} {
+		var total, prev time.Duration
+		for i := 0; i < 10; i++ {
+			prev = b.Next(i, prev)
+			total += prev
+		}
+		if total < defaultPollInterval {
+			t.Errorf("%T without base waits only %v in 10 tries", b, total)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package lockdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	select {
+	case <-clock.After(0):
+	default:
+		t.Error("After(0) did not fire at once")
+	}
+	ch := clock.After(time.Second)
+	if clock.Waiters() != 1 {
+		t.Fatalf("%d waiters, want 1", clock.Waiters())
+	}
+	clock.Advance(time.Millisecond * 999)
+	select {
+	case <-ch:
+		t.Fatal("After fired before its time")
+	default:
+	}
+	clock.Advance(time.Millisecond)
+	select {
+	case now := <-ch:
+		if want := start.Add(time.Second); !now.Equal(want) || !clock.Now().Equal(want) {
+			t.Errorf("fired at %v, now %v, want %v", now, clock.Now(), want)
+		}
+	default:
+		t.Fatal("After did not fire at its time")
+	}
+	if clock.Waiters() != 0 {
+		t.Errorf("%d waiters left after firing", clock.Waiters())
+	}
+}
+
+func TestOptionsDefaults(t *testing.T) {
+	o := Options{}.withDefaults()
+	if o.HeartbeatInterval != defaultHeartbeatInterval || o.LeaseTTL != defaultHeartbeatInterval*3 {
+		t.Errorf("heartbeat %v and lease %v by default", o.HeartbeatInterval, o.LeaseTTL)
+	}
+	if o.PollInterval != defaultPollInterval || o.MaxPollInterval != defaultPollInterval {
+		t.Errorf("poll %v up to %v by default", o.PollInterval, o.MaxPollInterval)
+	}
+	if o = (Options{LeaseTTL: -1}).withDefaults(); o.LeaseTTL != -1 || o.lease(time.Now()) != nil {
+		t.Errorf("negative lease turned into %v", o.LeaseTTL)
+	}
+	if o = (Options{HeartbeatInterval: time.Second}).withDefaults(); o.LeaseTTL != time.Second*3 {
+		t.Errorf("lease %v for a heartbeat of 1s", o.LeaseTTL)
+	}
+}
+
+func TestPollBackoff(t *testing.T) {
+	b := Options{PollInterval: time.Millisecond * 10, MaxPollInterval: time.Millisecond * 50}.withDefaults().Backoff
+	var prev time.Duration
+	for attempt, want := range []time.Duration{10, 20, 40, 50, 50} {
+		prev = b.Next(attempt, prev)
+		if prev != want*time.Millisecond {
+			t.Errorf("wait %d is %v, want %v", attempt, prev, want*time.Millisecond)
+		}
+	}
+
+	b = Options{PollInterval: time.Millisecond * 100, PollJitter: 0.2}.withDefaults().Backoff
+	for i := 0; i < 100; i++ {
+		if d := b.Next(0, 0); d < time.Millisecond*80 || d > time.Millisecond*120 {
+			t.Fatalf("wait %v is beyond 20%% jitter of 100ms", d)
+		}
+	}
+}
+
+func TestWithTimeoutOnFakeClock(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	ctx, cancel := Options{Clock: clock}.withDefaults().withTimeout(time.Second)
+	defer cancel()
+	waitWaiters(t, clock, 1)
+	if ctx.Err() != nil {
+		t.Fatal("the context is done before its timeout")
+	}
+	clock.Advance(time.Second)
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second * 5):
+		t.Fatal("the context is not done after its timeout on the clock")
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/cavanwang/demos/lockdb"
+	"gorm.io/gorm"
+)
+
+const (
+	// exitLockLost is the exit code when the child was killed because the
+	// lock was lost while it ran.
+	exitLockLost = 76
+	// killGrace is how long a child may exit by itself after SIGTERM.
+	killGrace = time.Second * 5
+)
+
+// exitCode makes main exit with the code without printing an error.
+type exitCode int
+
+func (c exitCode) Error() string {
+	return fmt.Sprintf("exit code %d", int(c))
+}
+
+// acquire runs a command while holding a lock, the lock is heartbeated while
+// the command runs and the command is killed once the lock is lost.
+func acquire(db *gorm.DB, args []string) error {
+	fs := flag.NewFlagSet("acquire", flag.ExitOnError)
+	timeout := fs.Duration("timeout", time.Second*10, "how long to wait for the lock, 0 for not waiting")
+	conflict := fs.Int("conflict-exit-code", 75, "exit code if the lock is not got in time")
+	fs.Parse(args)
+	args = fs.Args()
+	if len(args) < 3 || args[1] != "--" {
+		return errors.New("acquire needs a lock name, -- and a command")
+	}
+
+	name := args[0]
+	// The command is shown as the payload by list and show.
+	payload, err := json.Marshal(map[string][]string{"command": args[2:]})
+	if err != nil {
+		return err
+	}
+	locker := lockdb.NewDBLocker(db, lockdb.Options{})
+	var lock *lockdb.Lock
+	if *timeout <= 0 {
+		lock, err = locker.TryAcquire(name, lockdb.WithPayload(payload))
+	} else {
+		lock, err = locker.Acquire(name, *timeout, lockdb.WithPayload(payload))
+	}
+	if errors.Is(err, lockdb.ErrLockTimeout) || errors.Is(err, lockdb.ErrLockBusy) {
+		fmt.Fprintf(os.Stderr, "lockdb: %s is held by others\n", name)
+		return exitCode(*conflict)
+	}
+	if err != nil {
+		return err
+	}
+	defer locker.Release(lock)
+
+	return runLocked(lock, args[2], args[3:])
+}
+
+// runLocked runs the command forwarding the signals got by lockdb to it and
+// returns an exitCode of its status.
+func runLocked(lock *lockdb.Lock, name string, args []string) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(signals)
+
+	cmd := exec.Command(name, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	lost := lock.Lost()
+	for {
+		select {
+		case sig := <-signals:
+			cmd.Process.Signal(sig)
+		case <-lost:
+			fmt.Fprintf(os.Stderr, "lockdb: lock %s is lost, stopping %s\n", lock.Name, name)
+			cmd.Process.Signal(syscall.SIGTERM)
+			kill := time.AfterFunc(killGrace, func() { cmd.Process.Kill() })
+			<-done
+			kill.Stop()
+			return exitCode(exitLockLost)
+		case err := <-done:
+			return exitStatus(err)
+		}
+	}
+}
+
+// exitStatus turns the result of a command into its exit code, a command
+// killed by a signal exits with 128 plus the signal number like in shells.
+func exitStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return exitCode(128 + int(status.Signal()))
+	}
+	return exitCode(exitErr.ExitCode())
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/cavanwang/demos/lockdb"
+	"gorm.io/gorm"
+)
+
+// demo shows 2 goroutines preempting the lock name1.
+func demo(db *gorm.DB) {
+	var locker lockdb.Locker = lockdb.NewDBLocker(db, lockdb.Options{
+		LeaseTTL: time.Second * 10,
+		Logger:   slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	})
+	lockdb.ReleaseTimeoutLock(db, "name1", 10)
+
+	wg := sync.WaitGroup{}
+	f := func(name string) {
+		defer fmt.Printf("%v: %s exited\n", time.Now(), name)
+		defer wg.Done()
+
+		start := time.Now()
+		var lock *lockdb.Lock
+		var err error
+		for {
+			lock, err = locker.Acquire("name1", time.Second*10)
+			if err != nil {
+				fmt.Printf("%v: %s: lock name1 error=%v\n", time.Now(), name, err)
+				if err != lockdb.ErrLockTimeout {
+					return
+				}
+			} else {
+				break
+			}
+		}
+		fmt.Printf("%v: %s locked cost=%dms token=%d\n", time.Now(), name, time.Since(start)/time.Millisecond, lock.Token)
+		err = locker.Release(lock)
+		if err != nil {
+			fmt.Printf("%v: %s release name1 error=%v\n", time.Now(), name, err)
+			return
+		}
+		fmt.Printf("%v: %s release name1 ok\n", time.Now(), name)
+	}
+	wg.Add(2)
+	fmt.Printf("%v: started\n", time.Now())
+	go f("lock1")
+	go f("lock2")
+	wg.Wait()
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/cavanwang/demos/lockdb"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const usage = `Usage: lockdb [-db sqlite.db] <command> [arguments]
+
+Commands:
+  list                             list locks with holder, host, pid, age, heartbeat
+                                   staleness and payload
+  show <name>                      show the lock name and its holder process
+  release --force <name>           delete the lock name whoever holds it, flags
+                                   may also follow the name
+  reap --older-than 30s            delete locks not heartbeated for the duration
+  acquire [--timeout 10s] [--conflict-exit-code 75] <name> -- cmd args
+                                   run cmd while holding the lock name like flock(1),
+                                   exit with its status or the conflict exit code
+                                   if the lock is not got in time
+  demo                             show 2 goroutines preempting a lock
+`
+
+func main() {
+	flag.Usage = func() { fmt.Fprint(flag.CommandLine.Output(), usage) }
+	dbPath := flag.String("db", "sqlite.db", "sqlite database file")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	db, err := gorm.Open(sqlite.Open(*dbPath), &gorm.Config{
+		Logger:         logger.Default.LogMode(logger.Silent),
+		TranslateError: true,
+	})
+	if err != nil {
+		fail(err)
+	}
+	if err = lockdb.Migrate(db); err != nil {
+		fail(err)
+	}
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	switch cmd {
+	case "list":
+		err = list(db)
+	case "show":
+		err = show(db, args)
+	case "release":
+		err = release(db, args)
+	case "reap":
+		err = reap(db, args)
+	case "acquire":
+		err = acquire(db, args)
+	case "demo":
+		demo(db)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	var exit exitCode
+	if errors.As(err, &exit) {
+		os.Exit(int(exit))
+	}
+	if err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "lockdb: %v\n", err)
+	os.Exit(1)
+}
+
+func list(db *gorm.DB) error {
+	locks, err := lockdb.NewDBLocker(db, lockdb.Options{}).List()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tHOLDER\tHOST\tPID\tTOKEN\tAGE\tHEARTBEAT\tPAYLOAD")
+	for _, lock := range locks {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%v\t%v ago\t%s\n", lock.Name, holder(&lock), lock.Hostname, lock.PID,
+			lock.Token, since(lock.CreateAt), since(lock.HeartbeatAt), lock.Payload)
+	}
+	return w.Flush()
+}
+
+func show(db *gorm.DB, args []string) error {
+	if len(args) != 1 {
+		return errors.New("show needs a lock name")
+	}
+	lock, err := lockdb.NewDBLocker(db, lockdb.Options{}).Describe(args[0])
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "name:\t%s\n", lock.Name)
+	fmt.Fprintf(w, "holder:\t%s\n", holder(lock))
+	fmt.Fprintf(w, "holds:\t%d\n", lock.Holds)
+	fmt.Fprintf(w, "host:\t%s\n", lock.Hostname)
+	fmt.Fprintf(w, "pid:\t%d\n", lock.PID)
+	fmt.Fprintf(w, "process start:\t%v\n", lock.ProcessStart)
+	fmt.Fprintf(w, "payload:\t%s\n", lock.Payload)
+	fmt.Fprintf(w, "version:\t%s\n", lock.Version)
+	fmt.Fprintf(w, "token:\t%d\n", lock.Token)
+	fmt.Fprintf(w, "created:\t%v (%v ago)\n", lock.CreateAt, since(lock.CreateAt))
+	fmt.Fprintf(w, "heartbeat:\t%v (%v ago)\n", lock.HeartbeatAt, since(lock.HeartbeatAt))
+	if lock.ExpiresAt != nil {
+		fmt.Fprintf(w, "expires:\t%v (in %v)\n", *lock.ExpiresAt, -since(*lock.ExpiresAt))
+	} else {
+		fmt.Fprintf(w, "expires:\tnever\n")
+	}
+	return w.Flush()
+}
+
+func release(db *gorm.DB, args []string) error {
+	fs := flag.NewFlagSet("release", flag.ExitOnError)
+	force := fs.Bool("force", false, "delete the lock whoever holds it")
+	names := parseArgs(fs, args)
+	if len(names) != 1 {
+		return errors.New("release needs a lock name")
+	}
+	if !*force {
+		return errors.New("the lock is not held by this process, release it with --force")
+	}
+	if err := lockdb.NewDBLocker(db, lockdb.Options{}).ForceRelease(names[0]); err != nil {
+		return err
+	}
+	fmt.Printf("released %s\n", names[0])
+	return nil
+}
+
+// parseArgs parses the flags of fs wherever they are among args and returns
+// the other arguments, the flag package alone stops at the first of them.
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var rest []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			return rest
+		}
+		rest = append(rest, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+func reap(db *gorm.DB, args []string) error {
+	fs := flag.NewFlagSet("reap", flag.ExitOnError)
+	olderThan := fs.Duration("older-than", time.Second*30, "delete locks not heartbeated for this duration")
+	fs.Parse(args)
+	names, err := lockdb.NewDBLocker(db, lockdb.Options{}).ReapExpired(*olderThan)
+	for _, name := range names {
+		fmt.Printf("reaped %s\n", name)
+	}
+	return err
+}
+
+func holder(lock *lockdb.Lock) string {
+	if lock.Owner == "" {
+		return "-"
+	}
+	return lock.Owner
+}
+
+func since(t time.Time) time.Duration {
+	return time.Since(t).Round(time.Millisecond)
+}
@@ -0,0 +1,356 @@
+package lockdb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+// DBLocker is a Locker backed by the gorm Lock table, the unique index on
+// Name makes sure only one row, i.e. one holder, exists for a name.
+type DBLocker struct {
+	db      *gorm.DB
+	opts    Options
+	granted tracker
+}
+
+var _ Locker = (*DBLocker)(nil)
+
+func NewDBLocker(db *gorm.DB, opts Options) *DBLocker {
+	return &DBLocker{db: db, opts: opts.withDefaults()}
+}
+
+func (l *DBLocker) Acquire(name string, timeout time.Duration, opts ...AcquireOption) (*Lock, error) {
+	return acquireTimeout(l, l.opts, name, timeout, opts)
+}
+
+func (l *DBLocker) AcquireContext(ctx context.Context, name string, opts ...AcquireOption) (*Lock, error) {
+	start := l.opts.Clock.Now()
+	lock, err := countRoundTrips(ctx, func(ctx context.Context) (*Lock, error) {
+		return l.acquireContext(ctx, name, newAcquireOptions(opts))
+	})
+	observeAcquire(l.opts, name, start, lock, err)
+	return lock, err
+}
+
+func (l *DBLocker) acquireContext(ctx context.Context, name string, o acquireOptions) (*Lock, error) {
+	var lock *Lock
+	var err error
+	if l.opts.Fair {
+		lock, err = l.acquireFair(ctx, name, o)
+	} else {
+		lock, err = poll(ctx, l.opts, name, func(ctx context.Context) (*Lock, error) {
+			return l.tryAcquire(ctx, name, o)
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = l.waitShared(ctx, lock); err != nil {
+		l.Release(lock)
+		return nil, err
+	}
+	return lock, nil
+}
+
+func (l *DBLocker) TryAcquire(name string, opts ...AcquireOption) (*Lock, error) {
+	start := l.opts.Clock.Now()
+	lock, err := countRoundTrips(context.Background(), func(ctx context.Context) (*Lock, error) {
+		return l.tryAcquireFree(ctx, name, newAcquireOptions(opts))
+	})
+	err = busyError(err)
+	observeAcquire(l.opts, name, start, lock, err)
+	return lock, err
+}
+
+// tryAcquireFree takes the lock only if neither an exclusive nor a shared
+// holder exists.
+func (l *DBLocker) tryAcquireFree(ctx context.Context, name string, o acquireOptions) (*Lock, error) {
+	lock, err := l.tryAcquire(ctx, name, o)
+	if err != nil {
+		return nil, err
+	}
+	n, err := l.countShared(ctx, name)
+	if err == nil && n > 0 {
+		err = ErrLockBusy
+	}
+	if err != nil {
+		l.Release(lock)
+		return nil, err
+	}
+	return lock, nil
+}
+
+func (l *DBLocker) tryAcquire(ctx context.Context, name string, o acquireOptions) (*Lock, error) {
+	lock, err := l.preempt(l.db.WithContext(ctx), name, o)
+	if err != nil {
+		return nil, err
+	}
+	if err := l.hold(lock); err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// preempt creates, reenters or takes over the row of name by db, which may be
+// a transaction preempting several names.
+func (l *DBLocker) preempt(db *gorm.DB, name string, o acquireOptions) (*Lock, error) {
+	// Detect if the lock record existed.
+	lock := &Lock{}
+	result := db.Take(lock, "name=?", name)
+	if result.Error == nil {
+		if o.owner != "" && lock.Owner == o.owner && !l.expired(lock) {
+			if err := l.reenter(db, lock); err != nil {
+				return nil, err
+			}
+		} else if !l.expired(lock) {
+			return nil, ErrLockBusy
+		} else if err := l.takeover(db, lock, o); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, result.Error
+	} else {
+		// Try to insert a record to exclusively preempt the lock.
+		now := l.opts.Clock.Now()
+		lock = &Lock{Name: name, CreateAt: now, HeartbeatAt: now, ExpiresAt: l.opts.lease(now),
+			Version: uuid.NewV4().String(), Owner: o.owner, Holds: 1}
+		o.describe(lock)
+		err := db.Transaction(func(tx *gorm.DB) (err error) {
+			if lock.Token, err = nextToken(tx, name); err != nil {
+				return err
+			}
+			return tx.Create(lock).Error
+		})
+		if isDuplicateKeyError(err) {
+			return nil, ErrLockBusy
+		}
+		if err != nil {
+			l.opts.Logger.Warn("create lock failed", "lock", name, "error", err)
+			return nil, err
+		}
+	}
+
+	return lock, nil
+}
+
+// hold makes a heartbeat goroutine for a preempted lock, or releases it if
+// the locker is closed.
+func (l *DBLocker) hold(lock *Lock) error {
+	lock.state = newLockState()
+	if !l.granted.track(lock, l.heartbeat) {
+		l.Release(lock)
+		return ErrLockerClosed
+	}
+	return nil
+}
+
+func (l *DBLocker) expired(lock *Lock) bool {
+	return lock.expired(l.opts.Clock.Now())
+}
+
+// takeover steals a lock whose holder stopped heartbeating. The update is a
+// compare-and-swap on Version and on the lease still being expired, so only
+// one of the waiters seeing the same expired row wins, and none of them if
+// the holder renewed the lease meanwhile. The others get ErrLockBusy.
+func (l *DBLocker) takeover(db *gorm.DB, lock *Lock, o acquireOptions) error {
+	now := l.opts.Clock.Now()
+	expiresAt := l.opts.lease(now)
+	version := uuid.NewV4().String()
+	var token int64
+	err := db.Transaction(func(tx *gorm.DB) (err error) {
+		if token, err = nextToken(tx, lock.Name); err != nil {
+			return err
+		}
+		result := tx.Model(&Lock{}).Where("name=? and version=? and expires_at<?", lock.Name, lock.Version, now).
+			Updates(map[string]interface{}{"create_at": now, "heartbeat_at": now, "expires_at": expiresAt,
+				"version": version, "token": token,
+				"owner": o.owner, "holds": 1, "hostname": hostname, "pid": os.Getpid(),
+				"process_start": processStart, "payload": o.payload})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrLockBusy
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	l.opts.Metrics.TakenOver(lock.Name)
+	l.opts.Logger.Info("expired lock taken over", "lock", lock.Name, "old_version", lock.Version,
+		"old_holder", lock.Owner, "version", version, "holder", o.owner)
+	lock.CreateAt, lock.HeartbeatAt, lock.ExpiresAt, lock.Version, lock.Token = now, now, expiresAt, version, token
+	lock.Owner, lock.Holds = o.owner, 1
+	o.describe(lock)
+	return nil
+}
+
+// reenter adds a hold to a lock already held by the same owner, the returned
+// lock shares the version and token with the first hold.
+func (l *DBLocker) reenter(db *gorm.DB, lock *Lock) error {
+	result := db.Model(&Lock{}).Where("name=? and version=? and owner=?", lock.Name, lock.Version, lock.Owner).
+		Update("holds", gorm.Expr("holds+1"))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrLockBusy
+	}
+	lock.Holds++
+	return nil
+}
+
+// heartbeat refreshes the lock until it is released. Transient errors are
+// retried, but the lock is marked lost once the row is found owned by others,
+// or once no heartbeat succeeded until lostDeadline, which comes before a
+// waiter may take the lock over. A refresh hanging on the database is
+// abandoned at the deadline too.
+func (l *DBLocker) heartbeat(lock *Lock) {
+	clock := l.opts.Clock
+	last := lock.HeartbeatAt
+	for {
+		wait := l.opts.HeartbeatInterval
+		deadline, ok := l.lostDeadline(last)
+		if left := deadline.Sub(clock.Now()); ok && left < wait {
+			wait = left
+		}
+		select {
+		case <-lock.state.stopCh:
+			return
+		case <-clock.After(wait):
+		}
+
+		if ok && !clock.Now().Before(deadline) {
+			l.opts.Logger.Warn("lock lost for no heartbeat before the lease expires", append(lockAttrs(lock),
+				"since_heartbeat", clock.Now().Sub(last))...)
+			lock.state.markLost()
+			return
+		}
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if ok {
+			ctx, cancel = l.opts.withTimeout(deadline.Sub(clock.Now()))
+		}
+		err := l.refresh(ctx, lock)
+		cancel()
+		if err == nil {
+			last = lock.HeartbeatAt
+			l.opts.Logger.Debug("lock heartbeat", lockAttrs(lock)...)
+			continue
+		}
+		l.opts.Metrics.HeartbeatFailed(lock.Name)
+		if err == ErrLockLost {
+			l.opts.Logger.Warn("lock lost to another holder", lockAttrs(lock)...)
+			return
+		}
+		l.opts.Logger.Warn("lock heartbeat failed", append(lockAttrs(lock), "error", err)...)
+	}
+}
+
+// lostDeadline is when a holder whose last heartbeat succeeded at last gives
+// the lock up, a heartbeat interval but at most half the lease before the
+// lease expires. ok is false if the locks never expire.
+func (l *DBLocker) lostDeadline(last time.Time) (deadline time.Time, ok bool) {
+	if l.opts.LeaseTTL <= 0 {
+		return time.Time{}, false
+	}
+	margin := l.opts.HeartbeatInterval
+	if margin > l.opts.LeaseTTL/2 {
+		margin = l.opts.LeaseTTL / 2
+	}
+	return last.Add(l.opts.LeaseTTL - margin), true
+}
+
+// Refresh renews the heartbeat only if the row is still of lock's version,
+// otherwise the lock is marked lost and ErrLockLost is returned.
+func (l *DBLocker) Refresh(lock *Lock) error {
+	return l.refresh(context.Background(), lock)
+}
+
+func (l *DBLocker) refresh(ctx context.Context, lock *Lock) error {
+	now := l.opts.Clock.Now()
+	expiresAt := l.opts.lease(now)
+	result := l.db.WithContext(ctx).Model(lock.kind.model()).Where("name=? and version=?", lock.Name, lock.Version).
+		Updates(map[string]interface{}{"heartbeat_at": now, "expires_at": expiresAt})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		lock.state.markLost()
+		return ErrLockLost
+	}
+	lock.HeartbeatAt, lock.ExpiresAt = now, expiresAt
+	return nil
+}
+
+// Release gives up one hold of the lock, the row is deleted when no hold is
+// left. Only the row of lock's version is deleted, a lock taken over by
+// another holder is left to it and ErrNotHeld is returned.
+func (l *DBLocker) Release(lock *Lock) error {
+	return l.granted.release(lock, func() error {
+		return l.release(lock)
+	})
+}
+
+func (l *DBLocker) release(lock *Lock) error {
+	if lock.kind == kindExclusive && lock.Owner != "" {
+		result := l.db.Model(&Lock{}).Where("name=? and version=? and holds>1", lock.Name, lock.Version).
+			Update("holds", gorm.Expr("holds-1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			observeRelease(l.opts, lock)
+			return nil
+		}
+	}
+	result := l.db.Where("name=? and version=?", lock.Name, lock.Version).Delete(lock.kind.model())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		lock.state.markLost()
+		return ErrNotHeld
+	}
+	observeRelease(l.opts, lock)
+	notifyRelease(l.opts, lock.Name)
+	return nil
+}
+
+func (l *DBLocker) Close() error {
+	return l.granted.close(l.Release)
+}
+
+// ReleaseTimeout deletes the lock named name and its shared holders if they
+// have not heartbeated within heartbeatTimeout, released reports whether the
+// name is free of an exclusive holder now.
+func (l *DBLocker) ReleaseTimeout(name string, heartbeatTimeout time.Duration) (released bool, err error) {
+	if err = l.reapShared(l.db, name, heartbeatTimeout); err != nil {
+		return false, err
+	}
+
+	var lock Lock
+	result := l.db.Take(&lock, "name=?", name)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return true, nil
+	}
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	if l.opts.Clock.Now().Sub(lock.HeartbeatAt) <= heartbeatTimeout {
+		l.opts.Logger.Debug("lock not timed out", append(lockAttrs(&lock), "heartbeat_at", lock.HeartbeatAt)...)
+		return false, nil
+	}
+	result = l.db.Where("name=? and version = ?", name, lock.Version).Delete(&Lock{})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	notifyRelease(l.opts, name)
+	return true, nil
+}
@@ -0,0 +1,168 @@
+package lockdb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTakeoverFollowsHolderLease(t *testing.T) {
+	db := openTestDB(t)
+	holder := NewDBLocker(db, Options{HeartbeatInterval: time.Hour, LeaseTTL: time.Hour})
+	impatient := NewDBLocker(db, Options{LeaseTTL: time.Millisecond})
+	lock, err := holder.TryAcquire("name1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 20)
+	if _, err = impatient.TryAcquire("name1"); !errors.Is(err, ErrLockBusy) {
+		t.Fatalf("a lock within the lease of its holder is taken over: %v", err)
+	}
+	holder.Release(lock)
+
+	holder = NewDBLocker(db, Options{HeartbeatInterval: time.Hour, LeaseTTL: time.Millisecond * 10})
+	patient := NewDBLocker(db, Options{LeaseTTL: time.Hour})
+	lock, err = holder.TryAcquire("name1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 20)
+	next, err := patient.TryAcquire("name1")
+	if err != nil {
+		t.Fatalf("a lock whose lease expired is not taken over: %v", err)
+	}
+	if next.Token <= lock.Token {
+		t.Errorf("token of the takeover %d is not larger than %d", next.Token, lock.Token)
+	}
+	if err = holder.Refresh(lock); !errors.Is(err, ErrLockLost) {
+		t.Errorf("the old holder refreshed a taken over lock: %v", err)
+	}
+	select {
+	case <-lock.Lost():
+	default:
+		t.Error("the old holder is not told the lock is lost")
+	}
+}
+
+func TestTakeoverAfterRenewalFails(t *testing.T) {
+	db := openTestDB(t)
+	holder := NewDBLocker(db, Options{HeartbeatInterval: time.Hour, LeaseTTL: time.Millisecond * 10})
+	waiter := NewDBLocker(db, Options{})
+	lock, err := holder.TryAcquire("name1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 20)
+
+	// The waiter reads the row as expired, then the holder's heartbeat renews
+	// it.
+	var seen Lock
+	if err = db.Take(&seen, "name=?", "name1").Error; err != nil {
+		t.Fatal(err)
+	}
+	if !waiter.expired(&seen) {
+		t.Fatal("the lease did not expire")
+	}
+	err = db.Model(&Lock{}).Where("version=?", lock.Version).Update("expires_at", time.Now().Add(time.Hour)).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = waiter.takeover(db, &seen, acquireOptions{}); !errors.Is(err, ErrLockBusy) {
+		t.Errorf("a renewed lock is taken over: %v", err)
+	}
+	if row, err := waiter.Describe("name1"); err != nil || row.Version != lock.Version {
+		t.Errorf("the holder lost its renewed lock: %v", err)
+	}
+}
+
+func TestNoLeaseNeverExpires(t *testing.T) {
+	db := openTestDB(t)
+	holder := NewDBLocker(db, Options{HeartbeatInterval: time.Hour, LeaseTTL: -1})
+	lock, err := holder.TryAcquire("name1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.ExpiresAt != nil {
+		t.Fatalf("lock without lease expires at %v", lock.ExpiresAt)
+	}
+	if _, err = NewDBLocker(db, Options{LeaseTTL: time.Nanosecond}).TryAcquire("name1"); !errors.Is(err, ErrLockBusy) {
+		t.Errorf("a lock without lease is taken over: %v", err)
+	}
+}
+
+func TestLostBeforeLeaseExpires(t *testing.T) {
+	db := openTestDB(t)
+	l := NewDBLocker(db, Options{HeartbeatInterval: time.Millisecond * 100, LeaseTTL: time.Second})
+	lock, err := l.TryAcquire("name1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every heartbeat fails from now on.
+	if err = db.Exec("ALTER TABLE locks RENAME TO locks_away").Error; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-lock.Lost():
+		if !time.Now().Before(*lock.ExpiresAt) {
+			t.Errorf("lock is lost at %v, after its lease expired at %v", time.Now(), *lock.ExpiresAt)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("lock is not lost without heartbeat")
+	}
+}
+
+func TestFencingTokens(t *testing.T) {
+	db := openTestDB(t)
+	l := NewDBLocker(db, Options{})
+	var last int64
+	for i := 0; i < 3; i++ {
+		lock, err := l.TryAcquire("name1", WithOwner("o"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lock.Token <= last {
+			t.Errorf("token %d is not larger than the previous %d", lock.Token, last)
+		}
+		again, err := l.TryAcquire("name1", WithOwner("o"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again.Token != lock.Token {
+			t.Errorf("reentering changed the token from %d to %d", lock.Token, again.Token)
+		}
+		last = lock.Token
+		l.Release(again)
+		l.Release(lock)
+	}
+
+	other, err := l.TryAcquire("name2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Token != 1 {
+		t.Errorf("the first token of another name is %d", other.Token)
+	}
+}
+
+func TestAcquireContextCanceled(t *testing.T) {
+	db := openTestDB(t)
+	l := NewDBLocker(db, Options{PollInterval: time.Hour})
+	lock, err := l.TryAcquire("name1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Release(lock)
+
+	// The caller goes away, e.g. the HTTP client disconnected.
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*50, cancel)
+	start := time.Now()
+	_, err = l.AcquireContext(ctx, "name1")
+	if !errors.Is(err, context.Canceled) || errors.Is(err, ErrLockTimeout) {
+		t.Errorf("canceled acquisition returned %v", err)
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("canceled acquisition returned after %v", waited)
+	}
+}
@@ -0,0 +1,107 @@
+package lockdb
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Leadership is won by Campaign and held until Resign or lost when its
+// heartbeat fails, then another candidate takes over after the lease.
+type Leadership struct {
+	locker    *DBLocker
+	lock      *Lock
+	once      sync.Once
+	resigned  chan struct{}
+	resignErr error
+}
+
+// Campaign blocks until candidate becomes the leader of election or ctx is
+// done. Candidate IDs must be unique among the candidates, e.g. OwnerID(job).
+func (l *DBLocker) Campaign(ctx context.Context, election, candidate string) (*Leadership, error) {
+	if candidate == "" {
+		return nil, errors.New("campaign needs a candidate ID")
+	}
+	lock, err := l.AcquireContext(ctx, election, WithOwner(candidate))
+	if err != nil {
+		return nil, err
+	}
+	return &Leadership{locker: l, lock: lock, resigned: make(chan struct{})}, nil
+}
+
+// IsLeader tells if the leadership is neither lost nor resigned.
+func (s *Leadership) IsLeader() bool {
+	select {
+	case <-s.lock.Lost():
+		return false
+	case <-s.resigned:
+		return false
+	default:
+		return true
+	}
+}
+
+// Lost is closed once the leadership is lost, the leader should stop acting
+// as one at once.
+func (s *Leadership) Lost() <-chan struct{} {
+	return s.lock.Lost()
+}
+
+// Token is the fencing token of the leadership, the token of a later leader
+// is always larger.
+func (s *Leadership) Token() int64 {
+	return s.lock.Token
+}
+
+// Resign gives up the leadership so another candidate can win at once.
+func (s *Leadership) Resign() error {
+	s.once.Do(func() {
+		close(s.resigned)
+		s.resignErr = s.locker.Release(s.lock)
+	})
+	return s.resignErr
+}
+
+// Leader returns the candidate leading election now, or "" if none.
+func (l *DBLocker) Leader(election string) (string, error) {
+	lock, err := l.Describe(election)
+	if errors.Is(err, ErrLockNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if l.expired(lock) {
+		return "", nil
+	}
+	return lock.Owner, nil
+}
+
+// Observe sends the leader of election each time it changes, "" meaning no
+// leader, until ctx is done. The leader is checked 5 times a heartbeat
+// interval and errors are skipped till the next check.
+func (l *DBLocker) Observe(ctx context.Context, election string) <-chan string {
+	leaders := make(chan string)
+	go func() {
+		defer close(leaders)
+		last, first := "", true
+		for {
+			leader, err := l.Leader(election)
+			if err == nil && (first || leader != last) {
+				select {
+				case leaders <- leader:
+				case <-ctx.Done():
+					return
+				}
+				last, first = leader, false
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-l.opts.Clock.After(l.opts.HeartbeatInterval / 5):
+			}
+		}
+	}()
+	return leaders
+}
@@ -0,0 +1,95 @@
+package lockdb
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestElection(t *testing.T) {
+	db := openTestDB(t)
+	opts := Options{HeartbeatInterval: time.Millisecond * 20, LeaseTTL: time.Second, PollInterval: time.Millisecond * 5}
+	a, b := NewDBLocker(db, opts), NewDBLocker(db, opts)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	la, err := a.Campaign(ctx, "election1", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leader, err := b.Leader("election1"); err != nil || leader != "a" {
+		t.Fatalf("leader is %q, %v", leader, err)
+	}
+	leaders := b.Observe(ctx, "election1")
+	if leader := <-leaders; leader != "a" {
+		t.Fatalf("observed leader %q first", leader)
+	}
+
+	won := make(chan *Leadership, 1)
+	go func() {
+		lb, err := b.Campaign(ctx, "election1", "b")
+		if err != nil {
+			t.Error(err)
+		}
+		won <- lb
+	}()
+	select {
+	case <-won:
+		t.Fatal("a second leader is elected")
+	case <-time.After(time.Millisecond * 50):
+	}
+
+	if err = la.Resign(); err != nil {
+		t.Fatal(err)
+	}
+	if la.IsLeader() {
+		t.Error("a resigned leader is still leader")
+	}
+	lb := <-won
+	if lb == nil {
+		t.FailNow()
+	}
+	if lb.Token() <= la.Token() {
+		t.Errorf("token of the next leader %d is not larger than %d", lb.Token(), la.Token())
+	}
+	for leader := range leaders {
+		if leader == "b" {
+			break
+		}
+	}
+	lb.Resign()
+}
+
+func TestElectionFailover(t *testing.T) {
+	db := openTestDB(t)
+	// The leader never heartbeats in time, like a hung process.
+	hung := NewDBLocker(db, Options{HeartbeatInterval: time.Hour, LeaseTTL: time.Millisecond * 100})
+	next := NewDBLocker(db, Options{PollInterval: time.Millisecond * 5})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	old, err := hung.Campaign(ctx, "election1", "hung")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	leadership, err := next.Campaign(ctx, "election1", "next")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > time.Second*5 {
+		t.Errorf("failover took %v", time.Since(start))
+	}
+	select {
+	case <-old.Lost():
+	default:
+		t.Error("the old leader is not told it lost the leadership")
+	}
+	if old.IsLeader() {
+		t.Error("the old leader still thinks it leads")
+	}
+	if leader, err := next.Leader("election1"); err != nil || leader != "next" {
+		t.Errorf("leader is %q, %v", leader, err)
+	}
+	leadership.Resign()
+}
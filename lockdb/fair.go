@@ -0,0 +1,110 @@
+package lockdb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// LockTicket is the place of a waiter in the queue of a name when the locker
+// is fair. Tickets are granted by ID, i.e. arrival order, and a ticket whose
+// waiter let its lease expire is skipped and deleted.
+type LockTicket struct {
+	ID          uint
+	Name        string `gorm:"index"`
+	CreateAt    time.Time
+	HeartbeatAt time.Time
+	ExpiresAt   time.Time
+}
+
+// acquireFair waits in the queue of name and tries to preempt the lock only
+// when its ticket is the first one. The ticket is heartbeated by the tries,
+// whose waits are capped to a third of its lease so it does not expire while
+// the waiter is alive.
+func (l *DBLocker) acquireFair(ctx context.Context, name string, o acquireOptions) (*Lock, error) {
+	ticket := &LockTicket{Name: name}
+	defer func() {
+		if ticket.ID != 0 {
+			l.db.Delete(&LockTicket{}, ticket.ID)
+		}
+	}()
+
+	clock := l.opts.Clock
+	ttl := l.ticketTTL()
+	opts := l.opts
+	opts.Backoff = cappedBackoff{Backoff: opts.Backoff, max: ttl / 3}
+	return poll(ctx, opts, name, func(ctx context.Context) (*Lock, error) {
+		db := l.db.WithContext(ctx)
+		now := clock.Now()
+		if ticket.ID != 0 && now.Sub(ticket.HeartbeatAt) >= ttl/3 {
+			result := db.Model(ticket).Updates(map[string]interface{}{"heartbeat_at": now, "expires_at": now.Add(ttl)})
+			if result.Error != nil {
+				return nil, result.Error
+			}
+			if result.RowsAffected == 0 {
+				// Our ticket was reaped as abandoned, queue again.
+				ticket.ID = 0
+			}
+			ticket.HeartbeatAt, ticket.ExpiresAt = now, now.Add(ttl)
+		}
+		if ticket.ID == 0 {
+			ticket.CreateAt, ticket.HeartbeatAt, ticket.ExpiresAt = now, now, now.Add(ttl)
+			if err := db.Create(ticket).Error; err != nil {
+				return nil, err
+			}
+		}
+
+		first, err := l.firstTicket(db, name)
+		if err != nil {
+			return nil, err
+		}
+		if first == nil || first.ID > ticket.ID {
+			// Our ticket was skipped as abandoned, queue again.
+			ticket.ID = 0
+			return nil, ErrLockBusy
+		}
+		if first.ID != ticket.ID && !l.heldBy(db, name, o.owner) {
+			return nil, ErrLockBusy
+		}
+		return l.tryAcquire(ctx, name, o)
+	})
+}
+
+// ticketTTL is the lease of a ticket, the waiter's LeaseTTL or 3 heartbeat
+// intervals if its locks never expire.
+func (l *DBLocker) ticketTTL() time.Duration {
+	if l.opts.LeaseTTL > 0 {
+		return l.opts.LeaseTTL
+	}
+	return l.opts.HeartbeatInterval * 3
+}
+
+// firstTicket deletes the abandoned tickets of name and returns the first
+// one left, or nil if the queue is empty.
+func (l *DBLocker) firstTicket(db *gorm.DB, name string) (*LockTicket, error) {
+	err := db.Where("name=? and expires_at<?", name, l.opts.Clock.Now()).Delete(&LockTicket{}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var ticket LockTicket
+	err = db.Where("name=?", name).Order("id").Take(&ticket).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ticket, nil
+}
+
+// heldBy tells if name is held by owner, who may reenter without queueing.
+func (l *DBLocker) heldBy(db *gorm.DB, name string, owner string) bool {
+	if owner == "" {
+		return false
+	}
+	var lock Lock
+	return db.Take(&lock, "name=?", name).Error == nil && lock.Owner == owner
+}
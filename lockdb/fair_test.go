@@ -0,0 +1,103 @@
+package lockdb
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFairTicketOutlivesSlowPolls(t *testing.T) {
+	db := openTestDB(t)
+	holder := NewDBLocker(db, Options{HeartbeatInterval: time.Hour, LeaseTTL: time.Hour})
+	lock, err := holder.TryAcquire("name1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waiter := NewDBLocker(db, Options{Fair: true, LeaseTTL: time.Millisecond * 150,
+		Backoff: FixedBackoff{Interval: time.Hour}})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	acquired := make(chan error, 1)
+	go func() {
+		lock, err := waiter.AcquireContext(ctx, "name1")
+		if err == nil {
+			err = waiter.Release(lock)
+		}
+		acquired <- err
+	}()
+
+	tickets := func() (n int64) {
+		db.Model(&LockTicket{}).Where("name=? and expires_at>?", "name1", time.Now()).Count(&n)
+		return n
+	}
+	time.Sleep(time.Millisecond * 400)
+	if n := tickets(); n != 1 {
+		t.Fatalf("%d live tickets while waiting longer than their lease", n)
+	}
+
+	// A ticket reaped meanwhile is queued again by the next heartbeat.
+	db.Where("name=?", "name1").Delete(&LockTicket{})
+	time.Sleep(time.Millisecond * 150)
+	if n := tickets(); n != 1 {
+		t.Fatalf("%d live tickets after the ticket was reaped", n)
+	}
+
+	holder.Release(lock)
+	if err = <-acquired; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFairGrantsInArrivalOrder(t *testing.T) {
+	db := openTestDB(t)
+	holder := NewDBLocker(db, Options{})
+	lock, err := holder.TryAcquire("name1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waiter := NewDBLocker(db, Options{Fair: true, PollInterval: time.Millisecond * 5})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	const n = 5
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			lock, err := waiter.AcquireContext(ctx, "name1")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			waiter.Release(lock)
+		}(i)
+		// Queue the next waiter only after this one got its ticket.
+		for {
+			var queued int64
+			db.Model(&LockTicket{}).Where("name=?", "name1").Count(&queued)
+			if queued == int64(i+1) {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	holder.Release(lock)
+	wg.Wait()
+	for i, got := range order {
+		if got != i {
+			t.Fatalf("waiters got the lock in order %v", order)
+		}
+	}
+	if len(order) != n {
+		t.Errorf("%d of %d waiters got the lock", len(order), n)
+	}
+}
@@ -0,0 +1,111 @@
+package lockdb
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// tracker keeps the locks a locker granted and waits for their heartbeat
+// goroutines, its zero value is ready to use.
+type tracker struct {
+	mu     sync.Mutex
+	locks  map[*Lock]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// track starts heartbeating lock unless the locker was closed, the caller
+// must release a lock which is not tracked.
+func (t *tracker) track(lock *Lock, heartbeat func(*Lock)) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false
+	}
+	if t.locks == nil {
+		t.locks = make(map[*Lock]struct{})
+	}
+	t.locks[lock] = struct{}{}
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		heartbeat(lock)
+	}()
+	return true
+}
+
+// release gives up lock by give as lockState.release does, and stops
+// tracking it once it is released.
+func (t *tracker) release(lock *Lock, give func() error) error {
+	err := lock.state.release(give)
+	if err == nil || errors.Is(err, ErrNotHeld) {
+		t.untrack(lock)
+	}
+	return err
+}
+
+func (t *tracker) untrack(lock *Lock) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.locks, lock)
+}
+
+// close refuses new locks, releases the tracked ones and waits until all
+// heartbeat goroutines returned.
+func (t *tracker) close(release func(*Lock) error) error {
+	t.mu.Lock()
+	t.closed = true
+	locks := make([]*Lock, 0, len(t.locks))
+	for lock := range t.locks {
+		locks = append(locks, lock)
+	}
+	t.mu.Unlock()
+
+	var errs []error
+	for _, lock := range locks {
+		// A lock released meanwhile by its holder is not an error.
+		if err := release(lock); err != nil && !errors.Is(err, ErrNotHeld) {
+			errs = append(errs, err)
+		}
+	}
+	t.wg.Wait()
+	return errors.Join(errs...)
+}
+
+// CloseOnSignal closes l once the process gets one of signals, SIGINT and
+// SIGTERM if none is given, so its locks are released instead of lingering
+// until their lease expires. The signal is raised again afterwards to stop
+// the process as it would have been without the hook. The hook is removed
+// before closing, so a second signal stops a process whose Close hangs on
+// the database. stop removes the hook.
+func CloseOnSignal(l Locker, signals ...os.Signal) (stop func()) {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, signals...)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-ch:
+			signal.Stop(ch)
+			l.Close()
+			p, err := os.FindProcess(os.Getpid())
+			if err != nil || p.Signal(sig) != nil {
+				os.Exit(1)
+			}
+		case <-done:
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(done)
+		})
+	}
+}
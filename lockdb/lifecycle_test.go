@@ -0,0 +1,104 @@
+package lockdb
+
+import (
+	"errors"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDBLockerClose(t *testing.T) {
+	db := openTestDB(t)
+	l := NewDBLocker(db, Options{})
+	a, err := l.TryAcquire("name1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := l.TryAcquireShared("name2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	other := NewDBLocker(db, Options{})
+	for _, name := range []string{"name1", "name2"} {
+		lock, err := other.TryAcquire(name)
+		if err != nil {
+			t.Fatalf("%s is not released by Close: %v", name, err)
+		}
+		other.Release(lock)
+	}
+	for _, lock := range []*Lock{a, b} {
+		if err = l.Release(lock); !errors.Is(err, ErrNotHeld) {
+			t.Errorf("release of %s after Close: %v", lock.Name, err)
+		}
+	}
+	if _, err = l.TryAcquire("name1"); !errors.Is(err, ErrLockerClosed) {
+		t.Errorf("acquisition after Close: %v", err)
+	}
+	if err = l.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
+
+func TestTrackerCloseJoinsHeartbeats(t *testing.T) {
+	var tr tracker
+	var stopped atomic.Bool
+	lock := &Lock{state: newLockState()}
+	tr.track(lock, func(lock *Lock) {
+		<-lock.state.stopCh
+		time.Sleep(time.Millisecond * 20)
+		stopped.Store(true)
+	})
+	err := tr.close(func(lock *Lock) error {
+		return tr.release(lock, func() error { return nil })
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stopped.Load() {
+		t.Error("close returned before the heartbeat")
+	}
+	if tr.track(&Lock{state: newLockState()}, func(*Lock) {}) {
+		t.Error("a closed tracker tracks a lock")
+	}
+}
+
+func TestCloseOnSignal(t *testing.T) {
+	// SIGWINCH is ignored by default, so raising it again is harmless.
+	l := NewMemoryLocker(Options{})
+	lock, err := l.TryAcquire("name1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop := CloseOnSignal(l, syscall.SIGWINCH)
+	defer stop()
+	if err = syscall.Kill(syscall.Getpid(), syscall.SIGWINCH); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-lock.state.stopCh:
+	case <-time.After(time.Second * 5):
+		t.Fatal("the locker is not closed on the signal")
+	}
+	if _, err = l.TryAcquire("name2"); !errors.Is(err, ErrLockerClosed) {
+		t.Errorf("acquisition after the signal: %v", err)
+	}
+}
+
+func TestCloseOnSignalStop(t *testing.T) {
+	l := NewMemoryLocker(Options{})
+	defer l.Close()
+	// Another signal than TestCloseOnSignal, whose hook raises SIGWINCH again
+	// after closing, possibly while this test runs.
+	CloseOnSignal(l, syscall.SIGCHLD)()
+	syscall.Kill(syscall.Getpid(), syscall.SIGCHLD)
+	time.Sleep(time.Millisecond * 50)
+	lock, err := l.TryAcquire("name1")
+	if err != nil {
+		t.Fatalf("the locker is closed after the hook was removed: %v", err)
+	}
+	l.Release(lock)
+}
@@ -1,173 +1,358 @@
-package main
+// Package lockdb tries to lock a name with timeout across processes, like
+// mysql's GET_LOCK(name, timeout), by preempting a unique row in a table.
+package lockdb
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"os"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/glebarez/sqlite"
-	uuid "github.com/satori/go.uuid"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 )
 
-const (
-	tryGetLockEachMilliseconds = 50
+var (
+	ErrLockTimeout = errors.New("lock timeout")
+	ErrLockBusy    = errors.New("lock is held by others")
+	ErrLockLost    = errors.New("lock is lost")
+	// ErrNotHeld is returned by a release of a lock which was released
+	// already, or whose row is gone or owned by another holder.
+	ErrNotHeld = errors.New("lock is not held")
+	// ErrLockerClosed is returned by acquisitions after the locker is closed.
+	ErrLockerClosed = errors.New("locker is closed")
 )
 
 var (
-	ErrLockTimeout = errors.New("lock timeout")
+	hostname, _ = os.Hostname()
+	// processStart is when the process loaded lockdb, which tells a restarted
+	// process apart from the one holding a lock under the same PID.
+	processStart = time.Now()
 )
 
+// Locker acquires and releases named locks. Every backend implements it so
+// call sites never depend on how a lock is stored.
+type Locker interface {
+	// Acquire waits up to timeout for the lock, or returns ErrLockTimeout.
+	Acquire(name string, timeout time.Duration, opts ...AcquireOption) (*Lock, error)
+	// AcquireContext waits for the lock until ctx is done, the returned error
+	// wraps ctx.Err() and also ErrLockTimeout if the deadline exceeded.
+	AcquireContext(ctx context.Context, name string, opts ...AcquireOption) (*Lock, error)
+	// TryAcquire takes the lock only if it is free now, or returns ErrLockBusy.
+	TryAcquire(name string, opts ...AcquireOption) (*Lock, error)
+	// Release gives up a lock got from Acquire or TryAcquire, or returns
+	// ErrNotHeld if it is not held anymore. It is safe to call it more than
+	// once, concurrently too, and to call it again after it failed.
+	Release(lock *Lock) error
+	// Refresh renews the heartbeat of a held lock once.
+	Refresh(lock *Lock) error
+	// Close releases all locks the locker granted and not yet released, and
+	// waits for their heartbeat goroutines to return. Acquisitions fail with
+	// ErrLockerClosed afterwards. Locks failed to release are kept, so Close
+	// may be called again to retry them.
+	Close() error
+}
+
+// RWLocker is a Locker which also grants shared locks, like a distributed
+// sync.RWMutex. Exclusive locks are preferred: a waiting exclusive locker
+// blocks new shared holders and waits for the present ones to leave.
+type RWLocker interface {
+	Locker
+	AcquireShared(ctx context.Context, name string, opts ...AcquireOption) (*Lock, error)
+	TryAcquireShared(name string, opts ...AcquireOption) (*Lock, error)
+}
+
+// Lock is a held lock and also the row preempted in the locks table.
 type Lock struct {
 	ID          uint
 	Name        string `gorm:"uniqueIndex"`
 	CreateAt    time.Time
 	HeartbeatAt time.Time
-	Version     string
-	stopCh      chan struct{}
+	// ExpiresAt is when the lease of the holder runs out unless it heartbeats
+	// again, waiters take the lock over only after it. It is nil if the
+	// holder's locks never expire.
+	ExpiresAt *time.Time
+	Version   string
+	// Owner identifies the holder if it acquired with WithOwner, the same
+	// owner may acquire again and Holds counts how many times it holds the
+	// lock. Only the release of the last hold deletes the row.
+	Owner string
+	Holds int
+	// Hostname, PID and ProcessStart tell which process holds the lock and
+	// Payload is what it passed by WithPayload, e.g. the job it runs, so a
+	// stuck lock can be traced to its holder.
+	Hostname     string
+	PID          int `gorm:"column:pid"`
+	ProcessStart time.Time
+	Payload      string
+	// Token is the fencing token of this acquisition. It is taken from the
+	// LockSequence of the name in the same transaction that preempts the
+	// row, so every later acquisition of the name, including a takeover of
+	// an expired lock, gets a strictly larger token. Pass it along with
+	// writes and let the storage reject tokens smaller than the last seen.
+	Token int64
+	// Attempts is how many times a waiting acquisition tried and RoundTrips
+	// how many statements the acquisition sent to the database, which is
+	// counted only on a db set up by Instrument or Migrate. They are not
+	// stored.
+	Attempts   int   `gorm:"-"`
+	RoundTrips int64 `gorm:"-"`
+	kind       lockKind
+	state      *lockState
 }
 
-func GetLock(db *gorm.DB, name string, timeoutSecond int) (lock *Lock, err error) {
-	expire := time.Now().Add(time.Duration(timeoutSecond) * time.Second)
-	for ; ; time.Sleep(time.Millisecond * tryGetLockEachMilliseconds) {
-		// Detect if the lock record existed.
-		lock = &Lock{}
-		result := db.Take(lock, "name=?", name)
-		if result.Error != nil {
-			if isDuplicateKeyError(result.Error) {
-				result.Error = gorm.ErrDuplicatedKey
-				return nil, result.Error
-			}
-		}
+// lockKind tells which table a held Lock lives in.
+type lockKind int
 
-		if result.Error == nil {
-			// Try too many times, timeout.
-			if time.Since(expire) > 0 {
-				return nil, ErrLockTimeout
-			}
-			continue
-		}
+const (
+	kindExclusive lockKind = iota
+	kindShared
+	kindPermit
+)
 
-		// Try to insert a record to exclusively preempt the lock.
-		lock = &Lock{Name: name, CreateAt: time.Now(), HeartbeatAt: time.Now(), Version: uuid.NewV4().String()}
-		result = db.Create(lock)
-
-		// Preempted the lock successfully, make a heartbeat goroutine and return.
-		if result.Error == nil {
-			lock.stopCh = make(chan struct{})
-			go func() {
-				for {
-					select {
-					case <-lock.stopCh:
-						return
-					case <-time.After(time.Second * 5):
-						lock.HeartbeatAt = time.Now()
-						result := db.Save(lock)
-						if result.Error != nil {
-							fmt.Printf("%v: save lock error=%v\n", time.Now(), result.Error)
-							return
-						}
-						fmt.Printf("%v: save lock ok, heartbeat=%v\n", time.Now(), lock.HeartbeatAt)
-					}
-				}
-			}()
-
-			return lock, nil
-		}
+func (k lockKind) model() interface{} {
+	switch k {
+	case kindShared:
+		return &SharedLock{}
+	case kindPermit:
+		return &Permit{}
+	}
+	return &Lock{}
+}
 
-		if !errors.Is(result.Error, gorm.ErrDuplicatedKey) {
-			fmt.Printf("%v: error is not gorm.ErrDuplicatedKey %v\n", time.Now(), result.Error)
-			return nil, result.Error
-		}
+// Lost is closed once the holder finds it no longer owns the lock, e.g. the
+// lease expired and another process took it over. The critical section
+// should be aborted then.
+func (l *Lock) Lost() <-chan struct{} {
+	if l.state == nil {
+		return nil
+	}
+	return l.state.lostCh
+}
+
+// expired tells if the lease of the holder ran out at now.
+func (l *Lock) expired(now time.Time) bool {
+	return l.ExpiresAt != nil && now.After(*l.ExpiresAt)
+}
 
-		// DB has already a record, wait for other locker exiting.
-		if time.Since(expire) > 0 {
-			return nil, ErrLockTimeout
+// lockState is the in-process side of a held lock, it is a pointer so Lock
+// rows can be copied freely.
+type lockState struct {
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	lostCh   chan struct{}
+	lostOnce sync.Once
+	// releaseMu serializes the releases of the lock and released tells if
+	// one of them succeeded.
+	releaseMu sync.Mutex
+	released  bool
+	// conn is the session holding a lock of a native database backend.
+	conn *sql.Conn
+}
+
+func newLockState() *lockState {
+	return &lockState{stopCh: make(chan struct{}), lostCh: make(chan struct{})}
+}
+
+// stop stops the heartbeat.
+func (s *lockState) stop() {
+	s.stopOnce.Do(func() { close(s.stopCh) })
+}
+
+// release stops the heartbeat and gives up the lock by give, or returns
+// ErrNotHeld if an earlier release did. A release whose give failed may be
+// retried, the lock lives until its lease expires meanwhile.
+func (s *lockState) release(give func() error) error {
+	if s == nil {
+		return ErrNotHeld
+	}
+	s.releaseMu.Lock()
+	defer s.releaseMu.Unlock()
+	if s.released {
+		return ErrNotHeld
+	}
+	s.stop()
+	err := give()
+	if err == nil || errors.Is(err, ErrNotHeld) {
+		s.released = true
+	}
+	return err
+}
+
+func (s *lockState) markLost() {
+	if s == nil {
+		return
+	}
+	s.lostOnce.Do(func() { close(s.lostCh) })
+}
+
+// LockSequence keeps the last fencing token handed out for a name, it
+// outlives the Lock rows so tokens never go back after a release.
+type LockSequence struct {
+	Name  string `gorm:"primaryKey"`
+	Token int64
+}
+
+// AcquireOption customizes one acquisition.
+type AcquireOption func(*acquireOptions)
+
+type acquireOptions struct {
+	owner   string
+	payload string
+}
+
+func newAcquireOptions(opts []AcquireOption) acquireOptions {
+	var o acquireOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithOwner makes the lock reentrant for owner: acquiring a lock already held
+// by the same owner succeeds at once instead of waiting for itself.
+func WithOwner(owner string) AcquireOption {
+	return func(o *acquireOptions) {
+		o.owner = owner
+	}
+}
+
+// WithPayload stores payload, free-form JSON such as the job or request
+// holding the lock, on the lock row for operators to see.
+func WithPayload(payload json.RawMessage) AcquireOption {
+	return func(o *acquireOptions) {
+		o.payload = string(payload)
+	}
+}
+
+// describe fills in the holder of lock by this process and o.
+func (o acquireOptions) describe(lock *Lock) {
+	lock.Hostname, lock.PID, lock.ProcessStart = hostname, os.Getpid(), processStart
+	lock.Payload = o.payload
+}
+
+// OwnerID builds an owner identity of this host and process, token tells
+// apart the workers of the process, e.g. one token per goroutine or job.
+func OwnerID(token string) string {
+	return fmt.Sprintf("%s/%d/%s", hostname, os.Getpid(), token)
+}
+
+// Migrate creates or updates the tables used by lockdb and calls Instrument
+// on db, so call it before sharing db among goroutines.
+func Migrate(db *gorm.DB) error {
+	if err := Instrument(db); err != nil {
+		return err
+	}
+	return db.AutoMigrate(&Lock{}, &LockSequence{}, &SharedLock{}, &Permit{}, &LockTicket{}, &LockEvent{})
+}
+
+// nextToken increases and returns the fencing token of name, tx should be a
+// transaction that also preempts the lock.
+func nextToken(tx *gorm.DB, name string) (int64, error) {
+	result := tx.Model(&LockSequence{}).Where("name=?", name).Update("token", gorm.Expr("token+1"))
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		seq := LockSequence{Name: name, Token: 1}
+		if err := tx.Create(&seq).Error; err != nil {
+			return 0, err
 		}
+		return seq.Token, nil
 	}
+
+	var seq LockSequence
+	if err := tx.Take(&seq, "name=?", name).Error; err != nil {
+		return 0, err
+	}
+	return seq.Token, nil
+}
+
+// GetLock waits up to timeoutSecond seconds for the lock named name.
+func GetLock(db *gorm.DB, name string, timeoutSecond int) (lock *Lock, err error) {
+	return NewDBLocker(db, Options{}).Acquire(name, time.Duration(timeoutSecond)*time.Second)
 }
 
+// ReleaseLock releases a lock got from GetLock, or returns ErrNotHeld if it
+// was released already or lost to another holder.
 func ReleaseLock(db *gorm.DB, lock *Lock) error {
-	close(lock.stopCh)
-	result := db.Delete(lock)
-	return result.Error
+	return NewDBLocker(db, Options{}).Release(lock)
 }
 
+// ReleaseTimeoutLock deletes the lock named name if its holder has not
+// heartbeated for heartbeatTimeoutSecond seconds.
 func ReleaseTimeoutLock(db *gorm.DB, name string, heartbeatTimeoutSecond int) (released bool, err error) {
-	var lock Lock
-	result := db.Take(&lock, "name=?", name)
-	if result.Error == gorm.ErrRecordNotFound {
-		return true, nil
+	return NewDBLocker(db, Options{}).ReleaseTimeout(name, time.Duration(heartbeatTimeoutSecond)*time.Second)
+}
+
+// acquireTimeout implements Locker.Acquire by AcquireContext, it returns the
+// bare ErrLockTimeout so old callers comparing errors by == keep working.
+func acquireTimeout(l Locker, o Options, name string, timeout time.Duration, opts []AcquireOption) (*Lock, error) {
+	ctx, cancel := o.withTimeout(timeout)
+	defer cancel()
+	lock, err := l.AcquireContext(ctx, name, opts...)
+	if err != nil && ctx.Err() != nil {
+		return nil, ErrLockTimeout
 	}
-	if result.Error != nil {
-		return false, result.Error
+	return lock, err
+}
+
+// waitError tells why waiting for a lock stopped.
+func waitError(ctx context.Context, name string) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: %s: %w", ErrLockTimeout, name, ctx.Err())
 	}
+	return fmt.Errorf("wait lock %s: %w", name, ctx.Err())
+}
 
-	if time.Since(lock.HeartbeatAt) > time.Duration(heartbeatTimeoutSecond)*time.Second {
-		result = db.Where("name=? and version = ?", name, lock.Version).Delete(&Lock{})
-		if result.Error != nil {
-			return false, result.Error
-		}
-		return true, nil
-	} else {
-		fmt.Printf("heartbeat is %v\n", lock.HeartbeatAt)
+// discardConn closes the session of a pinned conn instead of returning it to
+// the pool, which makes the database free the locks of the session.
+func discardConn(conn *sql.Conn) {
+	conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+}
+
+// pinConn takes a connection out of the pool of db for a session scoped lock.
+func pinConn(ctx context.Context, db *gorm.DB) (*sql.Conn, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
 	}
-	return false, nil
+	return sqlDB.Conn(ctx)
 }
 
-func isDuplicateKeyError(err error) bool {
+// busyError maps the errors of a database too contended to serve a try to
+// ErrLockBusy, so the try is retried like one finding the lock held. E.g.
+// SQLite fails one of two transactions upgrading their read locks to write
+// locks at once, and MySQL and PostgreSQL abort deadlocked or unserializable
+// transactions.
+func busyError(err error) error {
 	if err == nil {
-		return false
+		return nil
+	}
+	msg := err.Error()
+	if strings.Contains(msg, "database is locked") || strings.Contains(msg, "SQLITE_BUSY") ||
+		strings.Contains(msg, "Deadlock found") || strings.Contains(msg, "Lock wait timeout exceeded") ||
+		strings.Contains(msg, "SQLSTATE 40001") || strings.Contains(msg, "SQLSTATE 40P01") {
+		return ErrLockBusy
 	}
-	return strings.Contains(err.Error(), "Duplicate entry") || strings.Contains(err.Error(), "UNIQUE constraint")
+	return err
 }
 
-func main() {
-	db, err := gorm.Open(sqlite.Open("sqlite.db"), &gorm.Config{
-		Logger:         logger.Default.LogMode(logger.Silent),
-		TranslateError: true,
-	})
-	if err != nil {
-		panic(err)
-	}
-	if err = db.AutoMigrate(&Lock{}); err != nil {
-		panic(err)
-	}
-
-	ReleaseTimeoutLock(db, "name1", 10)
-
-	wg := sync.WaitGroup{}
-	f := func(name string) {
-		defer fmt.Printf("%v: %s exited\n", time.Now(), name)
-		defer wg.Done()
-
-		start := time.Now()
-		var lock *Lock
-		for {
-			lock, err = GetLock(db, "name1", 10)
-			if err != nil {
-				fmt.Printf("%v: %s: lock name1 error=%v\n", time.Now(), name, err)
-				if err != ErrLockTimeout {
-					return
-				}
-			} else {
-				break
-			}
-		}
-		fmt.Printf("%v: %s locked cost=%dms\n", time.Now(), name, time.Since(start)/time.Millisecond)
-		err = ReleaseLock(db, lock)
-		if err != nil {
-			fmt.Printf("%v: %s release name1 error=%v\n", time.Now(), name, err)
-			return
-		}
-		fmt.Printf("%v: %s release name1 ok\n", time.Now(), name)
+// isDuplicateKeyError tells unique violations of MySQL, SQLite and PostgreSQL
+// (SQLSTATE 23505) apart when the dialector does not translate errors.
+func isDuplicateKeyError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return true
 	}
-	wg.Add(2)
-	fmt.Printf("%v: started\n", time.Now())
-	go f("lock1")
-	go f("lock2")
-	wg.Wait()
+	msg := err.Error()
+	return strings.Contains(msg, "Duplicate entry") || strings.Contains(msg, "UNIQUE constraint") ||
+		strings.Contains(msg, "SQLSTATE 23505") || strings.Contains(msg, "duplicate key value violates unique constraint")
 }
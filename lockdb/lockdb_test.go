@@ -0,0 +1,107 @@
+package lockdb
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openTestDB opens a migrated SQLite database in a temporary directory.
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := filepath.Join(t.TempDir(), "lock.db") + "?_pragma=busy_timeout(5000)"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		Logger:         logger.Default.LogMode(logger.Silent),
+		TranslateError: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+func TestGetLockConcurrent(t *testing.T) {
+	db := openTestDB(t)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				lock, err := GetLock(db, "counter", 10)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if err = ReleaseLock(db, lock); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRoundTrips(t *testing.T) {
+	db := openTestDB(t)
+	lock, err := NewDBLocker(db, Options{}).TryAcquire("name1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.RoundTrips == 0 {
+		t.Error("round trips of an acquisition are not counted")
+	}
+}
+
+func TestInstrumentWithoutMigrate(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "lock.db")
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	// The schema is managed by the application, not by Migrate.
+	err = db.AutoMigrate(&Lock{}, &LockSequence{}, &SharedLock{}, &Permit{}, &LockTicket{}, &LockEvent{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Instrument(db); err != nil {
+		t.Fatal(err)
+	}
+	if err = Instrument(db); err != nil {
+		t.Fatalf("second Instrument: %v", err)
+	}
+
+	m := NewPrometheusMetrics()
+	lock, err := NewDBLocker(db, Options{Metrics: m}).TryAcquire("name1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.RoundTrips == 0 {
+		t.Error("round trips of an acquisition are not counted")
+	}
+	out := scrape(t, m)
+	want := fmt.Sprintf("lockdb_acquire_round_trips_sum{lock=\"name1\"} %d\n", lock.RoundTrips)
+	if !strings.Contains(out, want) {
+		t.Errorf("missing %s in\n%s", want, out)
+	}
+}
@@ -0,0 +1,19 @@
+package lockdb
+
+import (
+	"context"
+	"log/slog"
+)
+
+// discardHandler drops every record, it keeps lockers quiet by default.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// lockAttrs are the fields logged about a held lock.
+func lockAttrs(lock *Lock) []any {
+	return []any{"lock", lock.Name, "version", lock.Version, "holder", lock.Owner}
+}
@@ -0,0 +1,203 @@
+package lockdb
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// MemoryLocker is a Locker keeping locks in process memory with the same
+// semantics as DBLocker: timeouts, reentrant owners, fencing tokens,
+// heartbeats and takeover of locks not heartbeated for the lease TTL. It
+// runs on Options.Clock, so tests can drive expiry with a FakeClock.
+type MemoryLocker struct {
+	opts Options
+
+	mu      sync.Mutex
+	locks   map[string]*memoryEntry
+	tokens  map[string]int64
+	changed chan struct{}
+	granted tracker
+}
+
+// memoryEntry is the row of a held lock and the states of its holds.
+type memoryEntry struct {
+	lock   Lock
+	states []*lockState
+}
+
+var _ Locker = (*MemoryLocker)(nil)
+
+func NewMemoryLocker(opts Options) *MemoryLocker {
+	opts = opts.withDefaults()
+	return &MemoryLocker{
+		opts:    opts,
+		locks:   make(map[string]*memoryEntry),
+		tokens:  make(map[string]int64),
+		changed: make(chan struct{}),
+	}
+}
+
+func (l *MemoryLocker) Acquire(name string, timeout time.Duration, opts ...AcquireOption) (*Lock, error) {
+	return acquireTimeout(l, l.opts, name, timeout, opts)
+}
+
+// AcquireContext waits until the lock is released, or the lease of its
+// holder expires on the clock, or ctx is done.
+func (l *MemoryLocker) AcquireContext(ctx context.Context, name string, opts ...AcquireOption) (*Lock, error) {
+	start := l.opts.Clock.Now()
+	lock, err := l.acquireContext(ctx, name, newAcquireOptions(opts))
+	observeAcquire(l.opts, name, start, lock, err)
+	return lock, err
+}
+
+func (l *MemoryLocker) acquireContext(ctx context.Context, name string, o acquireOptions) (*Lock, error) {
+	var wait time.Duration
+	for attempt := 0; ; attempt++ {
+		l.mu.Lock()
+		lock, err := l.tryAcquire(name, o)
+		changed := l.changed
+		l.mu.Unlock()
+		if err == nil {
+			lock.Attempts = attempt + 1
+		}
+		if err != ErrLockBusy {
+			return lock, err
+		}
+		wait = l.opts.Backoff.Next(attempt, wait)
+
+		select {
+		case <-ctx.Done():
+			return nil, waitError(ctx, name)
+		case <-changed:
+		case <-l.opts.Clock.After(wait):
+		}
+	}
+}
+
+func (l *MemoryLocker) TryAcquire(name string, opts ...AcquireOption) (*Lock, error) {
+	start := l.opts.Clock.Now()
+	l.mu.Lock()
+	lock, err := l.tryAcquire(name, newAcquireOptions(opts))
+	l.mu.Unlock()
+	observeAcquire(l.opts, name, start, lock, err)
+	return lock, err
+}
+
+// tryAcquire is called with l.mu held.
+func (l *MemoryLocker) tryAcquire(name string, o acquireOptions) (*Lock, error) {
+	now := l.opts.Clock.Now()
+	entry := l.locks[name]
+	if entry != nil {
+		expired := entry.lock.expired(now)
+		if o.owner != "" && entry.lock.Owner == o.owner && !expired {
+			entry.lock.Holds++
+			return l.hold(entry)
+		}
+		if !expired {
+			return nil, ErrLockBusy
+		}
+		for _, state := range entry.states {
+			state.markLost()
+		}
+		l.opts.Metrics.TakenOver(name)
+	}
+
+	l.tokens[name]++
+	entry = &memoryEntry{lock: Lock{Name: name, CreateAt: now, HeartbeatAt: now, ExpiresAt: l.opts.lease(now),
+		Version: uuid.NewV4().String(), Owner: o.owner, Holds: 1, Token: l.tokens[name]}}
+	o.describe(&entry.lock)
+	l.locks[name] = entry
+	return l.hold(entry)
+}
+
+// hold makes a heartbeated Lock of entry, or releases it if the locker is
+// closed, called with l.mu held.
+func (l *MemoryLocker) hold(entry *memoryEntry) (*Lock, error) {
+	lock := &Lock{}
+	*lock = entry.lock
+	lock.state = newLockState()
+	entry.states = append(entry.states, lock.state)
+	if !l.granted.track(lock, l.heartbeat) {
+		l.release(lock)
+		return nil, ErrLockerClosed
+	}
+	return lock, nil
+}
+
+func (l *MemoryLocker) heartbeat(lock *Lock) {
+	for {
+		select {
+		case <-lock.state.stopCh:
+			return
+		case <-l.opts.Clock.After(l.opts.HeartbeatInterval):
+		}
+		if err := l.Refresh(lock); err != nil {
+			l.opts.Metrics.HeartbeatFailed(lock.Name)
+			return
+		}
+	}
+}
+
+// Refresh renews the heartbeat if lock still owns the name, otherwise the lock
+// is marked lost.
+func (l *MemoryLocker) Refresh(lock *Lock) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry := l.locks[lock.Name]
+	if entry == nil || entry.lock.Version != lock.Version {
+		lock.state.markLost()
+		return ErrLockLost
+	}
+	now := l.opts.Clock.Now()
+	entry.lock.HeartbeatAt, entry.lock.ExpiresAt = now, l.opts.lease(now)
+	lock.HeartbeatAt, lock.ExpiresAt = entry.lock.HeartbeatAt, entry.lock.ExpiresAt
+	return nil
+}
+
+// Release gives up one hold of the lock. A lock which was taken over is left
+// to the new holder and ErrNotHeld is returned.
+func (l *MemoryLocker) Release(lock *Lock) error {
+	return l.granted.release(lock, func() error {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return l.release(lock)
+	})
+}
+
+// release is Release called with l.mu held.
+func (l *MemoryLocker) release(lock *Lock) error {
+	entry := l.locks[lock.Name]
+	if entry == nil || entry.lock.Version != lock.Version {
+		lock.state.markLost()
+		return ErrNotHeld
+	}
+	for i, state := range entry.states {
+		if state == lock.state {
+			entry.states = append(entry.states[:i], entry.states[i+1:]...)
+			break
+		}
+	}
+	observeRelease(l.opts, lock)
+	if entry.lock.Holds--; entry.lock.Holds > 0 {
+		return nil
+	}
+	delete(l.locks, lock.Name)
+	close(l.changed)
+	l.changed = make(chan struct{})
+	return nil
+}
+
+func (l *MemoryLocker) Close() error {
+	return l.granted.close(l.Release)
+}
+
+// Abandon stops heartbeating lock without releasing it, like its holder
+// crashed, so tests can check the lock is taken over after the lease. A
+// release afterwards returns ErrNotHeld.
+func (l *MemoryLocker) Abandon(lock *Lock) {
+	// A release giving up nothing, the entry is left to expire.
+	l.granted.release(lock, func() error { return nil })
+}
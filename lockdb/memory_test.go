@@ -0,0 +1,177 @@
+package lockdb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitWaiters waits until n After channels of clock are pending, so the
+// goroutines under test are parked before the clock is advanced.
+func waitWaiters(t *testing.T, clock *FakeClock, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 5)
+	for clock.Waiters() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d clock waiters, want %d", clock.Waiters(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMemoryTakeover(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	l := NewMemoryLocker(Options{Clock: clock, HeartbeatInterval: time.Second, LeaseTTL: time.Second * 3})
+	defer l.Close()
+	crashed, err := l.TryAcquire("name1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Abandon(crashed)
+	clock.Advance(time.Second * 2)
+	if _, err = l.TryAcquire("name1"); !errors.Is(err, ErrLockBusy) {
+		t.Fatalf("a lock within its lease is taken over: %v", err)
+	}
+	clock.Advance(time.Second * 2)
+	next, err := l.TryAcquire("name1")
+	if err != nil {
+		t.Fatalf("an abandoned lock is not taken over after its lease: %v", err)
+	}
+	if next.Token <= crashed.Token {
+		t.Errorf("token of the takeover %d is not larger than %d", next.Token, crashed.Token)
+	}
+	if err = l.Release(crashed); !errors.Is(err, ErrNotHeld) {
+		t.Errorf("release of an abandoned lock: %v", err)
+	}
+}
+
+func TestMemoryHeartbeatKeepsLock(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	l := NewMemoryLocker(Options{Clock: clock, HeartbeatInterval: time.Second, LeaseTTL: time.Second * 3})
+	defer l.Close()
+	lock, err := l.TryAcquire("name1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		waitWaiters(t, clock, 1)
+		clock.Advance(time.Second)
+	}
+	waitWaiters(t, clock, 1)
+	if _, err = l.TryAcquire("name1"); !errors.Is(err, ErrLockBusy) {
+		t.Errorf("a heartbeated lock is taken over: %v", err)
+	}
+	select {
+	case <-lock.Lost():
+		t.Error("a heartbeated lock is lost")
+	default:
+	}
+}
+
+func TestMemoryTokens(t *testing.T) {
+	l := NewMemoryLocker(Options{})
+	defer l.Close()
+	var last int64
+	for i := 0; i < 3; i++ {
+		lock, err := l.TryAcquire("name1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lock.Token <= last {
+			t.Errorf("token %d is not larger than the previous %d", lock.Token, last)
+		}
+		last = lock.Token
+		l.Release(lock)
+	}
+}
+
+func TestMemoryReentrant(t *testing.T) {
+	l := NewMemoryLocker(Options{})
+	defer l.Close()
+	first, err := l.TryAcquire("name1", WithOwner("o"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := l.TryAcquire("name1", WithOwner("o"))
+	if err != nil {
+		t.Fatalf("the owner can not reenter its lock: %v", err)
+	}
+	if second.Token != first.Token {
+		t.Errorf("reentering changed the token from %d to %d", first.Token, second.Token)
+	}
+	if _, err = l.TryAcquire("name1", WithOwner("other")); !errors.Is(err, ErrLockBusy) {
+		t.Fatalf("another owner entered the lock: %v", err)
+	}
+	l.Release(second)
+	if _, err = l.TryAcquire("name1"); !errors.Is(err, ErrLockBusy) {
+		t.Fatalf("the lock is free while the owner holds it once more: %v", err)
+	}
+	l.Release(first)
+	lock, err := l.TryAcquire("name1")
+	if err != nil {
+		t.Fatalf("the lock is not free after the last release: %v", err)
+	}
+	l.Release(lock)
+}
+
+func TestMemoryAcquireTimeout(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	l := NewMemoryLocker(Options{Clock: clock, HeartbeatInterval: time.Hour, LeaseTTL: -1})
+	defer l.Close()
+	lock, err := l.TryAcquire("name1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitWaiters(t, clock, 1)
+
+	acquired := make(chan error, 1)
+	go func() {
+		_, err := l.Acquire("name1", time.Second*10)
+		acquired <- err
+	}()
+	// The heartbeat, the timeout and the poll of the waiter.
+	waitWaiters(t, clock, 3)
+	clock.Advance(time.Second * 10)
+	if err = <-acquired; !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("waiting for a held lock: %v", err)
+	}
+
+	go func() {
+		lock, err := l.AcquireContext(context.Background(), "name1")
+		if err == nil {
+			err = l.Release(lock)
+		}
+		acquired <- err
+	}()
+	waitWaiters(t, clock, 2)
+	if err = l.Release(lock); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-acquired; err != nil {
+		t.Errorf("a waiter is not woken by the release: %v", err)
+	}
+}
+
+func TestMemoryClose(t *testing.T) {
+	l := NewMemoryLocker(Options{})
+	a, err := l.TryAcquire("name1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := l.TryAcquire("name2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, lock := range []*Lock{a, b} {
+		if err = l.Release(lock); !errors.Is(err, ErrNotHeld) {
+			t.Errorf("release of %s after Close: %v", lock.Name, err)
+		}
+	}
+	if _, err = l.TryAcquire("name1"); !errors.Is(err, ErrLockerClosed) {
+		t.Errorf("acquisition after Close: %v", err)
+	}
+}
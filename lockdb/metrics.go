@@ -0,0 +1,57 @@
+package lockdb
+
+import (
+	"errors"
+	"time"
+)
+
+// Metrics receives the instrumentation of a locker, it must be safe for
+// concurrent use. PrometheusMetrics is a ready-made implementation.
+type Metrics interface {
+	// Acquired is called when the lock name is got after waiting wait.
+	Acquired(name string, wait time.Duration)
+	// TimedOut is called when waiting for the lock name timed out.
+	TimedOut(name string, wait time.Duration)
+	// Released is called when the lock name is released after held for held.
+	Released(name string, held time.Duration)
+	// HeartbeatFailed is called when renewing the lock name failed.
+	HeartbeatFailed(name string)
+	// TakenOver is called when an expired lock name is taken over.
+	TakenOver(name string)
+	// RoundTrips is called with the statements an acquisition of name sent
+	// to a database set up by Instrument.
+	RoundTrips(name string, n int64)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) Acquired(string, time.Duration) {}
+func (nopMetrics) TimedOut(string, time.Duration) {}
+func (nopMetrics) Released(string, time.Duration) {}
+func (nopMetrics) HeartbeatFailed(string)         {}
+func (nopMetrics) TakenOver(string)               {}
+func (nopMetrics) RoundTrips(string, int64)       {}
+
+// observeAcquire logs and measures how an acquisition of name which started
+// at start ended.
+func observeAcquire(o Options, name string, start time.Time, lock *Lock, err error) {
+	wait := o.Clock.Now().Sub(start)
+	if err != nil {
+		if errors.Is(err, ErrLockTimeout) {
+			o.Metrics.TimedOut(name, wait)
+		}
+		o.Logger.Debug("acquire lock failed", "lock", name, "latency", wait, "error", err)
+		return
+	}
+	o.Metrics.Acquired(name, wait)
+	if lock.RoundTrips > 0 {
+		o.Metrics.RoundTrips(name, lock.RoundTrips)
+	}
+	o.Logger.Debug("lock acquired", append(lockAttrs(lock), "token", lock.Token, "attempts", lock.Attempts,
+		"round_trips", lock.RoundTrips, "latency", wait)...)
+}
+
+// observeRelease measures a released lock.
+func observeRelease(o Options, lock *Lock) {
+	o.Metrics.Released(lock.Name, o.Clock.Now().Sub(lock.CreateAt))
+}
@@ -0,0 +1,108 @@
+package lockdb
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// AcquireAll waits until it holds the locks of all names at once. The names
+// are preempted in sorted order inside one transaction, so either all or
+// none of them are taken by a try and two callers locking overlapping names
+// can not deadlock each other. Release them by ReleaseAll.
+func (l *DBLocker) AcquireAll(ctx context.Context, names ...string) ([]*Lock, error) {
+	names = canonicalNames(names)
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	start := l.opts.Clock.Now()
+	var locks []*Lock
+	_, err := pollNames(ctx, l.opts, strings.Join(names, ","), names, func(ctx context.Context) (*Lock, error) {
+		var err error
+		if locks, err = l.tryAcquireAll(ctx, names); err != nil {
+			return nil, err
+		}
+		return locks[0], nil
+	})
+	if err == nil {
+		for _, lock := range locks {
+			if err = l.waitShared(ctx, lock); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			l.ReleaseAll(locks...)
+		}
+	}
+	for i, name := range names {
+		if err != nil {
+			observeAcquire(l.opts, name, start, nil, err)
+			continue
+		}
+		observeAcquire(l.opts, name, start, locks[i], nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return locks, nil
+}
+
+func (l *DBLocker) tryAcquireAll(ctx context.Context, names []string) ([]*Lock, error) {
+	var locks []*Lock
+	err := l.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		locks = locks[:0]
+		for _, name := range names {
+			lock, err := l.preempt(tx, name, acquireOptions{})
+			if err != nil {
+				return err
+			}
+			locks = append(locks, lock)
+		}
+		return nil
+	})
+	if isDuplicateKeyError(err) {
+		return nil, ErrLockBusy
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, lock := range locks {
+		if err := l.hold(lock); err != nil {
+			// The locker is closed, hold releases the rest of the locks too.
+			for _, rest := range locks[i+1:] {
+				l.hold(rest)
+			}
+			l.ReleaseAll(locks[:i]...)
+			return nil, err
+		}
+	}
+	return locks, nil
+}
+
+// ReleaseAll releases locks got from AcquireAll in the reverse order.
+func (l *DBLocker) ReleaseAll(locks ...*Lock) error {
+	var errs []error
+	for i := len(locks) - 1; i >= 0; i-- {
+		if err := l.Release(locks[i]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// canonicalNames sorts names and drops duplicates.
+func canonicalNames(names []string) []string {
+	names = append([]string(nil), names...)
+	sort.Strings(names)
+	var canonical []string
+	for _, name := range names {
+		if len(canonical) == 0 || name != canonical[len(canonical)-1] {
+			canonical = append(canonical, name)
+		}
+	}
+	return canonical
+}
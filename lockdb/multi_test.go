@@ -0,0 +1,76 @@
+package lockdb
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAcquireAllConcurrent(t *testing.T) {
+	db := openTestDB(t)
+	l := NewDBLocker(db, Options{})
+	var mu sync.Mutex
+	held := make(map[string]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			names := []string{"a", "b", "c"}
+			if i%2 == 1 {
+				names = []string{"c", "b", "a", "a"}
+			}
+			for j := 0; j < 5; j++ {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+				locks, err := l.AcquireAll(ctx, names...)
+				cancel()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				for _, lock := range locks {
+					if held[lock.Name] {
+						t.Errorf("%s is held twice", lock.Name)
+					}
+					held[lock.Name] = true
+				}
+				mu.Unlock()
+
+				mu.Lock()
+				for _, lock := range locks {
+					held[lock.Name] = false
+				}
+				mu.Unlock()
+				if err = l.ReleaseAll(locks...); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestAcquireAllNoneOnTimeout(t *testing.T) {
+	db := openTestDB(t)
+	l := NewDBLocker(db, Options{})
+	b, err := l.TryAcquire("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+	defer cancel()
+	if _, err = l.AcquireAll(ctx, "c", "b", "a"); err == nil {
+		t.Fatal("AcquireAll got a held lock")
+	}
+	locks, err := l.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != 1 || locks[0].Name != "b" {
+		t.Errorf("locks left after a failed AcquireAll: %v", locks)
+	}
+	l.Release(b)
+}
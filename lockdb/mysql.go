@@ -0,0 +1,191 @@
+package lockdb
+
+import (
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+// mysqlMaxLockName is the longest name GET_LOCK accepts.
+const mysqlMaxLockName = 64
+
+// MySQLLocker is a Locker on MySQL's own GET_LOCK and RELEASE_LOCK, no table
+// is needed. Each lock pins a connection of the pool since MySQL drops the
+// locks of a session when its connection closes, which also frees the locks
+// of a crashed holder at once. The locks are not reentrant across
+// acquisitions and Lock.Token is always 0.
+type MySQLLocker struct {
+	db      *gorm.DB
+	opts    Options
+	granted tracker
+}
+
+var _ Locker = (*MySQLLocker)(nil)
+
+// NewMySQLLocker makes a locker on db which must be opened by a MySQL
+// dialector.
+func NewMySQLLocker(db *gorm.DB, opts Options) *MySQLLocker {
+	return &MySQLLocker{db: db, opts: opts.withDefaults()}
+}
+
+func (l *MySQLLocker) Acquire(name string, timeout time.Duration, opts ...AcquireOption) (*Lock, error) {
+	return acquireTimeout(l, l.opts, name, timeout, opts)
+}
+
+// AcquireContext waits in GET_LOCK by rounds of one second so that ctx is
+// checked between rounds, besides the driver aborts a round once ctx is done.
+func (l *MySQLLocker) AcquireContext(ctx context.Context, name string, opts ...AcquireOption) (*Lock, error) {
+	start := l.opts.Clock.Now()
+	lock, err := l.acquireContext(ctx, name, newAcquireOptions(opts))
+	observeAcquire(l.opts, name, start, lock, err)
+	return lock, err
+}
+
+func (l *MySQLLocker) acquireContext(ctx context.Context, name string, o acquireOptions) (*Lock, error) {
+	conn, err := pinConn(ctx, l.db)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if ctx.Err() != nil {
+			conn.Close()
+			return nil, waitError(ctx, name)
+		}
+		lock, err := l.getLock(ctx, conn, name, o, 1)
+		if err == nil {
+			return lock, nil
+		}
+		if ctx.Err() != nil {
+			conn.Close()
+			return nil, waitError(ctx, name)
+		}
+		if err != ErrLockBusy {
+			conn.Close()
+			return nil, err
+		}
+	}
+}
+
+func (l *MySQLLocker) TryAcquire(name string, opts ...AcquireOption) (*Lock, error) {
+	start := l.opts.Clock.Now()
+	lock, err := l.tryAcquire(name, newAcquireOptions(opts))
+	observeAcquire(l.opts, name, start, lock, err)
+	return lock, err
+}
+
+func (l *MySQLLocker) tryAcquire(name string, o acquireOptions) (*Lock, error) {
+	ctx := context.Background()
+	conn, err := pinConn(ctx, l.db)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := l.getLock(ctx, conn, name, o, 0)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return lock, nil
+}
+
+// getLock calls GET_LOCK on conn waiting up to waitSecond seconds.
+func (l *MySQLLocker) getLock(ctx context.Context, conn *sql.Conn, name string, o acquireOptions, waitSecond int) (*Lock, error) {
+	var got sql.NullInt64
+	err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", mysqlLockName(name), waitSecond).Scan(&got)
+	if err != nil {
+		return nil, err
+	}
+	if !got.Valid {
+		return nil, fmt.Errorf("GET_LOCK %s returned NULL", name)
+	}
+	if got.Int64 != 1 {
+		return nil, ErrLockBusy
+	}
+
+	now := l.opts.Clock.Now()
+	lock := &Lock{Name: name, CreateAt: now, HeartbeatAt: now, Version: uuid.NewV4().String()}
+	o.describe(lock)
+	lock.state = newLockState()
+	lock.state.conn = conn
+	if !l.granted.track(lock, l.heartbeat) {
+		l.Release(lock)
+		return nil, ErrLockerClosed
+	}
+	return lock, nil
+}
+
+// heartbeat checks the session still owns the lock, the lock is marked lost
+// once the connection breaks or another session owns it.
+func (l *MySQLLocker) heartbeat(lock *Lock) {
+	for {
+		select {
+		case <-lock.state.stopCh:
+			return
+		case <-l.opts.Clock.After(l.opts.HeartbeatInterval):
+		}
+
+		if err := l.Refresh(lock); err != nil {
+			l.opts.Metrics.HeartbeatFailed(lock.Name)
+			l.opts.Logger.Warn("lock session lost", append(lockAttrs(lock), "error", err)...)
+			lock.state.markLost()
+			return
+		}
+	}
+}
+
+func (l *MySQLLocker) Refresh(lock *Lock) error {
+	var owned sql.NullBool
+	err := lock.state.conn.QueryRowContext(context.Background(),
+		"SELECT IS_USED_LOCK(?) = CONNECTION_ID()", mysqlLockName(lock.Name)).Scan(&owned)
+	if err != nil {
+		return err
+	}
+	if !owned.Valid || !owned.Bool {
+		lock.state.markLost()
+		return ErrLockLost
+	}
+	lock.HeartbeatAt = l.opts.Clock.Now()
+	return nil
+}
+
+func (l *MySQLLocker) Close() error {
+	return l.granted.close(l.Release)
+}
+
+// Release returns ErrNotHeld if the session of lock does not own it, e.g.
+// its connection broke. If RELEASE_LOCK fails the session is closed, which
+// frees the lock too.
+func (l *MySQLLocker) Release(lock *Lock) error {
+	return l.granted.release(lock, func() error {
+		conn := lock.state.conn
+		var released sql.NullInt64
+		err := conn.QueryRowContext(context.Background(), "SELECT RELEASE_LOCK(?)", mysqlLockName(lock.Name)).
+			Scan(&released)
+		if err != nil {
+			l.opts.Logger.Warn("release lock failed, closing its session instead", append(lockAttrs(lock), "error", err)...)
+			discardConn(conn)
+		} else {
+			defer conn.Close()
+			if !released.Valid || released.Int64 != 1 {
+				lock.state.markLost()
+				return ErrNotHeld
+			}
+		}
+		observeRelease(l.opts, lock)
+		return nil
+	})
+}
+
+// mysqlLockName hashes names too long for GET_LOCK.
+func mysqlLockName(name string) string {
+	if len(name) <= mysqlMaxLockName {
+		return name
+	}
+	sum := sha1.Sum([]byte(name))
+	return "lockdb:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,103 @@
+package lockdb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openMySQL opens the database of LOCKDB_MYSQL_DSN, the test is skipped
+// without it.
+func openMySQL(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("LOCKDB_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("LOCKDB_MYSQL_DSN is not set")
+	}
+	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+func TestMySQLLockName(t *testing.T) {
+	if got := mysqlLockName("name1"); got != "name1" {
+		t.Errorf("short name is changed to %s", got)
+	}
+	long := strings.Repeat("x", mysqlMaxLockName+1)
+	got := mysqlLockName(long)
+	if len(got) > mysqlMaxLockName {
+		t.Errorf("hashed name %s is longer than %d", got, mysqlMaxLockName)
+	}
+	if got == mysqlLockName(long+"y") {
+		t.Error("different long names hash to the same lock")
+	}
+}
+
+func TestMySQLLocker(t *testing.T) {
+	db := openMySQL(t)
+	l := NewMySQLLocker(db, Options{HeartbeatInterval: time.Hour})
+	defer l.Close()
+	for _, name := range []string{"lockdb_test", "lockdb_test_" + strings.Repeat("x", mysqlMaxLockName)} {
+		lock, err := l.TryAcquire(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = l.TryAcquire(name); !errors.Is(err, ErrLockBusy) {
+			t.Errorf("%s: a held lock is acquired by another session: %v", name, err)
+		}
+		if err = l.Refresh(lock); err != nil {
+			t.Errorf("%s: refresh of a held lock: %v", name, err)
+		}
+		if err = l.Release(lock); err != nil {
+			t.Fatal(err)
+		}
+		if err = l.Release(lock); !errors.Is(err, ErrNotHeld) {
+			t.Errorf("%s: second release: %v", name, err)
+		}
+	}
+}
+
+func TestMySQLAcquireTimeout(t *testing.T) {
+	db := openMySQL(t)
+	l := NewMySQLLocker(db, Options{HeartbeatInterval: time.Hour})
+	defer l.Close()
+	lock, err := l.TryAcquire("lockdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	if _, err = l.AcquireContext(ctx, "lockdb_test"); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("waiting for a held lock: %v", err)
+	}
+
+	acquired := make(chan error, 1)
+	go func() {
+		lock, err := l.AcquireContext(context.Background(), "lockdb_test")
+		if err == nil {
+			err = l.Release(lock)
+		}
+		acquired <- err
+	}()
+	time.Sleep(time.Millisecond * 100)
+	if err = l.Release(lock); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-acquired; err != nil {
+		t.Errorf("waiter of a released lock: %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package lockdb
+
+import (
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// defaultNotifier is shared by the lockers of the process which have no
+// Options.Notifier, so a ReleaseLock wakes the GetLock waiters of the same
+// process although each call makes its own locker.
+var defaultNotifier = NewLocalNotifier()
+
+// lockEventRetention is how long a TableNotifier keeps the events, it must
+// be longer than the tail interval of any process.
+const lockEventRetention = time.Minute
+
+// Notifier tells waiters that a lock name was released so they try at once
+// instead of at their next poll. Polling goes on anyway, a lost notification
+// only delays a waiter.
+type Notifier interface {
+	// Notify announces that name was released.
+	Notify(name string) error
+	// Watch returns a channel closed by the next Notify of name.
+	Watch(name string) <-chan struct{}
+}
+
+// LocalNotifier is a Notifier within the process.
+type LocalNotifier struct {
+	mu       sync.Mutex
+	watchers map[string]chan struct{}
+}
+
+func NewLocalNotifier() *LocalNotifier {
+	return &LocalNotifier{watchers: make(map[string]chan struct{})}
+}
+
+func (n *LocalNotifier) Notify(name string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if ch, ok := n.watchers[name]; ok {
+		close(ch)
+		delete(n.watchers, name)
+	}
+	return nil
+}
+
+func (n *LocalNotifier) Watch(name string) <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	ch, ok := n.watchers[name]
+	if !ok {
+		ch = make(chan struct{})
+		n.watchers[name] = ch
+	}
+	return ch
+}
+
+// LockEvent is a release logged by a TableNotifier.
+type LockEvent struct {
+	ID       uint
+	Name     string
+	CreateAt time.Time `gorm:"index"`
+}
+
+// TableNotifier is a Notifier across processes sharing a database. Releases
+// are appended to the lock_events table which every process tails by one
+// query per interval, instead of each of its waiters polling the locks.
+type TableNotifier struct {
+	local     *LocalNotifier
+	db        *gorm.DB
+	interval  time.Duration
+	clock     Clock
+	stopCh    chan struct{}
+	doneCh    chan struct{}
+	closeOnce sync.Once
+}
+
+// TableNotifierOption customizes a TableNotifier.
+type TableNotifierOption func(*TableNotifier)
+
+// WithNotifierClock makes the notifier tell the time by clock, which should
+// be the Options.Clock of the lockers using it. The real clock by default.
+func WithNotifierClock(clock Clock) TableNotifierOption {
+	return func(n *TableNotifier) {
+		n.clock = clock
+	}
+}
+
+// NewTableNotifier starts tailing the events of db every interval until
+// Close, the table is created by Migrate.
+func NewTableNotifier(db *gorm.DB, interval time.Duration, opts ...TableNotifierOption) (*TableNotifier, error) {
+	var last uint
+	if err := db.Model(&LockEvent{}).Select("coalesce(max(id), 0)").Scan(&last).Error; err != nil {
+		return nil, err
+	}
+	n := &TableNotifier{local: NewLocalNotifier(), db: db, interval: interval, clock: realClock{},
+		stopCh: make(chan struct{}), doneCh: make(chan struct{})}
+	for _, opt := range opts {
+		opt(n)
+	}
+	go n.tail(last)
+	return n, nil
+}
+
+// Notify wakes the local waiters at once and logs the release for the other
+// processes, old events are deleted now and then.
+func (n *TableNotifier) Notify(name string) error {
+	n.local.Notify(name)
+	event := LockEvent{Name: name, CreateAt: n.clock.Now()}
+	if err := n.db.Create(&event).Error; err != nil {
+		return err
+	}
+	if event.ID%100 == 0 {
+		return n.db.Where("create_at<?", event.CreateAt.Add(-lockEventRetention)).Delete(&LockEvent{}).Error
+	}
+	return nil
+}
+
+func (n *TableNotifier) Watch(name string) <-chan struct{} {
+	return n.local.Watch(name)
+}
+
+// Close stops tailing the events, it may be called more than once.
+func (n *TableNotifier) Close() error {
+	n.closeOnce.Do(func() { close(n.stopCh) })
+	<-n.doneCh
+	return nil
+}
+
+// tail notifies the local waiters of the events after last. A failed query
+// is retried at the next interval.
+func (n *TableNotifier) tail(last uint) {
+	defer close(n.doneCh)
+	for {
+		select {
+		case <-n.stopCh:
+			return
+		case <-n.clock.After(n.interval):
+		}
+
+		var events []LockEvent
+		if err := n.db.Where("id>?", last).Order("id").Find(&events).Error; err != nil {
+			continue
+		}
+		for _, event := range events {
+			n.local.Notify(event.Name)
+			last = event.ID
+		}
+	}
+}
+
+// notifyRelease tells the waiters of name it was released, a failure only
+// delays them to their next poll.
+func notifyRelease(o Options, name string) {
+	if err := o.Notifier.Notify(name); err != nil {
+		o.Logger.Warn("notify lock release failed", "lock", name, "error", err)
+	}
+}
+
+// watch returns a channel closed once any of names is released, stop frees
+// the goroutines watching several names.
+func watch(n Notifier, names []string) (<-chan struct{}, func()) {
+	if len(names) == 1 {
+		return n.Watch(names[0]), func() {}
+	}
+	released, stopCh := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	for _, name := range names {
+		go func(ch <-chan struct{}) {
+			select {
+			case <-ch:
+				once.Do(func() { close(released) })
+			case <-stopCh:
+			}
+		}(n.Watch(name))
+	}
+	return released, func() { close(stopCh) }
+}
@@ -0,0 +1,84 @@
+package lockdb
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// testWakeup checks that a waiter of waiters, which would poll only once an
+// hour, gets name at once when holders releases it.
+func testWakeup(t *testing.T, holders, waiters *DBLocker, names ...string) {
+	t.Helper()
+	held, err := holders.AcquireAll(context.Background(), names...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	acquired := make(chan error, 1)
+	go func() {
+		locks, err := waiters.AcquireAll(ctx, names...)
+		if err == nil {
+			err = waiters.ReleaseAll(locks...)
+		}
+		acquired <- err
+	}()
+	time.Sleep(time.Millisecond * 50)
+	if err = holders.ReleaseAll(held...); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-acquired; err != nil {
+		t.Errorf("the waiter is not woken by the release: %v", err)
+	}
+}
+
+func TestLocalNotifierWakesWaiter(t *testing.T) {
+	db := openTestDB(t)
+	notifier := NewLocalNotifier()
+	holders := NewDBLocker(db, Options{Notifier: notifier})
+	waiters := NewDBLocker(db, Options{Notifier: notifier, PollInterval: time.Hour})
+	testWakeup(t, holders, waiters, "name1")
+	// Watching several names, the waiter is woken by any of them.
+	testWakeup(t, holders, waiters, "name1", "name2")
+}
+
+func TestTableNotifierWakesWaiter(t *testing.T) {
+	db := openTestDB(t)
+	// Two notifiers on the table stand for two processes.
+	n1, err := NewTableNotifier(db, time.Millisecond*10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n1.Close()
+	n2, err := NewTableNotifier(db, time.Millisecond*10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n2.Close()
+	holders := NewDBLocker(db, Options{Notifier: n1})
+	waiters := NewDBLocker(db, Options{Notifier: n2, PollInterval: time.Hour})
+	testWakeup(t, holders, waiters, "name1")
+	testWakeup(t, holders, waiters, "name1", "name2")
+}
+
+func TestTableNotifierClock(t *testing.T) {
+	db := openTestDB(t)
+	clock := NewFakeClock(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+	n, err := NewTableNotifier(db, time.Second, WithNotifierClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = n.Notify("name1"); err != nil {
+		t.Fatal(err)
+	}
+	var event LockEvent
+	if err = db.Last(&event).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !event.CreateAt.Equal(clock.Now()) {
+		t.Errorf("event created at %v, not at %v of the clock", event.CreateAt, clock.Now())
+	}
+	n.Close()
+	n.Close()
+}
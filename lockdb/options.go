@@ -0,0 +1,154 @@
+package lockdb
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+const (
+	defaultHeartbeatInterval = time.Second * 5
+	defaultPollInterval      = time.Millisecond * 50
+)
+
+// Options tunes a Locker, the zero value means defaults.
+type Options struct {
+	// HeartbeatInterval is how often a holder renews its lock, 5s by default.
+	HeartbeatInterval time.Duration
+	// LeaseTTL is how long a lock lives without heartbeat before a waiter
+	// may take it over, 3 heartbeat intervals by default and negative means
+	// the locks never expire. It is stored on the rows as Lock.ExpiresAt, so
+	// waiters follow the lease of the holder whatever their own LeaseTTL,
+	// provided the clocks of the hosts agree well within it.
+	LeaseTTL time.Duration
+	// PollInterval is the wait after the first busy try of a lock, 50ms by
+	// default.
+	PollInterval time.Duration
+	// MaxPollInterval caps the wait which doubles after each busy try, it is
+	// PollInterval by default, i.e. polling at a fixed interval.
+	MaxPollInterval time.Duration
+	// PollJitter randomizes each wait by up to this fraction of it, e.g. 0.2
+	// for 20%, so that contenders do not poll in lockstep.
+	PollJitter float64
+	// Backoff replaces the waits made of PollInterval, MaxPollInterval and
+	// PollJitter, e.g. by an ExponentialBackoff under heavy contention.
+	Backoff Backoff
+	// Clock tells the time, the real clock by default.
+	Clock Clock
+	// Logger receives structured logs of acquisitions, heartbeats and
+	// takeovers, nothing is logged by default.
+	Logger *slog.Logger
+	// Metrics receives counters and durations of the locks, e.g. a
+	// PrometheusMetrics, nothing is measured by default.
+	Metrics Metrics
+	// Fair grants the lock to waiters of AcquireContext in arrival order
+	// instead of letting them race, TryAcquire may still barge in.
+	Fair bool
+	// Notifier wakes waiters when a lock is released, by default the lockers
+	// of the process share a LocalNotifier. Use a TableNotifier to also wake
+	// the waiters of other processes.
+	Notifier Notifier
+}
+
+func (o Options) withDefaults() Options {
+	if o.HeartbeatInterval <= 0 {
+		o.HeartbeatInterval = defaultHeartbeatInterval
+	}
+	if o.LeaseTTL == 0 {
+		o.LeaseTTL = o.HeartbeatInterval * 3
+	}
+	if o.PollInterval <= 0 {
+		o.PollInterval = defaultPollInterval
+	}
+	if o.MaxPollInterval < o.PollInterval {
+		o.MaxPollInterval = o.PollInterval
+	}
+	if o.Backoff == nil {
+		o.Backoff = pollBackoff{interval: o.PollInterval, max: o.MaxPollInterval, jitter: o.PollJitter}
+	}
+	if o.Clock == nil {
+		o.Clock = realClock{}
+	}
+	if o.Logger == nil {
+		o.Logger = slog.New(discardHandler{})
+	}
+	if o.Metrics == nil {
+		o.Metrics = nopMetrics{}
+	}
+	if o.Notifier == nil {
+		o.Notifier = defaultNotifier
+	}
+	return o
+}
+
+// lease returns when a lock heartbeated at now expires, or nil if the locks
+// never expire.
+func (o Options) lease(now time.Time) *time.Time {
+	if o.LeaseTTL <= 0 {
+		return nil
+	}
+	expiresAt := now.Add(o.LeaseTTL)
+	return &expiresAt
+}
+
+// withTimeout is context.WithTimeout on the clock of o.
+func (o Options) withTimeout(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := o.Clock.(realClock); ok {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-o.Clock.After(timeout):
+			cancel()
+		}
+	}()
+	return ctx, cancel
+}
+
+// poll calls try until it succeeds, fails with an error other than
+// ErrLockBusy or ctx is done, waiting between tries by o.Backoff or until
+// name is released.
+func poll(ctx context.Context, o Options, name string, try func(ctx context.Context) (*Lock, error)) (*Lock, error) {
+	return pollNames(ctx, o, name, []string{name}, try)
+}
+
+// pollNames is poll waking when any of names is released, label names them
+// in errors.
+func pollNames(ctx context.Context, o Options, label string, names []string,
+	try func(ctx context.Context) (*Lock, error)) (*Lock, error) {
+	var wait time.Duration
+	for attempt := 0; ; attempt++ {
+		// Watch before the try so a release right after it is not missed.
+		released, stop := watch(o.Notifier, names)
+		lock, err := try(ctx)
+		err = busyError(err)
+		if err == nil {
+			stop()
+			if lock != nil {
+				lock.Attempts = attempt + 1
+			}
+			return lock, nil
+		}
+		if ctx.Err() != nil {
+			stop()
+			return nil, waitError(ctx, label)
+		}
+		if err != ErrLockBusy {
+			stop()
+			return nil, err
+		}
+
+		wait = o.Backoff.Next(attempt, wait)
+		select {
+		case <-ctx.Done():
+		case <-released:
+		case <-o.Clock.After(wait):
+		}
+		stop()
+		if ctx.Err() != nil {
+			return nil, waitError(ctx, label)
+		}
+	}
+}
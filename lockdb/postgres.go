@@ -0,0 +1,174 @@
+package lockdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+// PostgresLocker is a Locker on PostgreSQL's session advisory locks, no table
+// is needed. Names are hashed to the 64 bits advisory keys and each lock pins
+// a connection of the pool, closing it frees the lock. The locks are not
+// reentrant across acquisitions and Lock.Token is always 0.
+type PostgresLocker struct {
+	db      *gorm.DB
+	opts    Options
+	granted tracker
+}
+
+var _ Locker = (*PostgresLocker)(nil)
+
+// NewPostgresLocker makes a locker on db which must be opened by a
+// PostgreSQL dialector.
+func NewPostgresLocker(db *gorm.DB, opts Options) *PostgresLocker {
+	return &PostgresLocker{db: db, opts: opts.withDefaults()}
+}
+
+func (l *PostgresLocker) Acquire(name string, timeout time.Duration, opts ...AcquireOption) (*Lock, error) {
+	return acquireTimeout(l, l.opts, name, timeout, opts)
+}
+
+func (l *PostgresLocker) AcquireContext(ctx context.Context, name string, opts ...AcquireOption) (*Lock, error) {
+	start := l.opts.Clock.Now()
+	lock, err := l.acquireContext(ctx, name, newAcquireOptions(opts))
+	observeAcquire(l.opts, name, start, lock, err)
+	return lock, err
+}
+
+func (l *PostgresLocker) acquireContext(ctx context.Context, name string, o acquireOptions) (*Lock, error) {
+	conn, err := pinConn(ctx, l.db)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := poll(ctx, l.opts, name, func(ctx context.Context) (*Lock, error) {
+		return l.tryLock(ctx, conn, name, o)
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return lock, nil
+}
+
+func (l *PostgresLocker) TryAcquire(name string, opts ...AcquireOption) (*Lock, error) {
+	start := l.opts.Clock.Now()
+	lock, err := l.tryAcquire(name, newAcquireOptions(opts))
+	observeAcquire(l.opts, name, start, lock, err)
+	return lock, err
+}
+
+func (l *PostgresLocker) tryAcquire(name string, o acquireOptions) (*Lock, error) {
+	ctx := context.Background()
+	conn, err := pinConn(ctx, l.db)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := l.tryLock(ctx, conn, name, o)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return lock, nil
+}
+
+func (l *PostgresLocker) tryLock(ctx context.Context, conn *sql.Conn, name string, o acquireOptions) (*Lock, error) {
+	var got bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", advisoryKey(name)).Scan(&got); err != nil {
+		return nil, err
+	}
+	if !got {
+		return nil, ErrLockBusy
+	}
+
+	now := l.opts.Clock.Now()
+	lock := &Lock{Name: name, CreateAt: now, HeartbeatAt: now, Version: uuid.NewV4().String()}
+	o.describe(lock)
+	lock.state = newLockState()
+	lock.state.conn = conn
+	if !l.granted.track(lock, l.heartbeat) {
+		l.Release(lock)
+		return nil, ErrLockerClosed
+	}
+	return lock, nil
+}
+
+// heartbeat checks that the session still holds the lock, the lock is marked
+// lost once the connection breaks since PostgreSQL frees it with the session.
+func (l *PostgresLocker) heartbeat(lock *Lock) {
+	for {
+		select {
+		case <-lock.state.stopCh:
+			return
+		case <-l.opts.Clock.After(l.opts.HeartbeatInterval):
+		}
+
+		if err := l.Refresh(lock); err != nil {
+			l.opts.Metrics.HeartbeatFailed(lock.Name)
+			l.opts.Logger.Warn("lock session lost", append(lockAttrs(lock), "error", err)...)
+			lock.state.markLost()
+			return
+		}
+	}
+}
+
+// Refresh checks in pg_locks that the session of lock still holds its
+// advisory lock, which a bigint key splits into classid and objid.
+func (l *PostgresLocker) Refresh(lock *Lock) error {
+	key := advisoryKey(lock.Name)
+	var held int
+	err := lock.state.conn.QueryRowContext(context.Background(),
+		"SELECT count(*) FROM pg_locks WHERE locktype='advisory' AND pid=pg_backend_pid()"+
+			" AND classid=$1 AND objid=$2 AND objsubid=1 AND granted",
+		int64(uint32(key>>32)), int64(uint32(key))).Scan(&held)
+	if err != nil {
+		lock.state.markLost()
+		return fmt.Errorf("%w: %w", ErrLockLost, err)
+	}
+	if held == 0 {
+		lock.state.markLost()
+		return ErrLockLost
+	}
+	lock.HeartbeatAt = l.opts.Clock.Now()
+	return nil
+}
+
+func (l *PostgresLocker) Close() error {
+	return l.granted.close(l.Release)
+}
+
+// Release returns ErrNotHeld if the session of lock does not hold the
+// advisory lock, e.g. its connection broke. If the unlock fails the session
+// is closed, which frees the lock too.
+func (l *PostgresLocker) Release(lock *Lock) error {
+	return l.granted.release(lock, func() error {
+		conn := lock.state.conn
+		var released bool
+		err := conn.QueryRowContext(context.Background(), "SELECT pg_advisory_unlock($1)", advisoryKey(lock.Name)).
+			Scan(&released)
+		if err != nil {
+			l.opts.Logger.Warn("release lock failed, closing its session instead", append(lockAttrs(lock), "error", err)...)
+			discardConn(conn)
+		} else {
+			defer conn.Close()
+			if !released {
+				lock.state.markLost()
+				return ErrNotHeld
+			}
+		}
+		observeRelease(l.opts, lock)
+		notifyRelease(l.opts, lock.Name)
+		return nil
+	})
+}
+
+// advisoryKey hashes name to a key of pg_advisory_lock.
+func advisoryKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
@@ -0,0 +1,78 @@
+package lockdb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openPostgres opens the database of LOCKDB_POSTGRES_DSN, the test is
+// skipped without it.
+func openPostgres(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := os.Getenv("LOCKDB_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("LOCKDB_POSTGRES_DSN is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+func TestPostgresLocker(t *testing.T) {
+	db := openPostgres(t)
+	l := NewPostgresLocker(db, Options{HeartbeatInterval: time.Hour})
+	defer l.Close()
+	lock, err := l.TryAcquire("lockdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = l.TryAcquire("lockdb_test"); !errors.Is(err, ErrLockBusy) {
+		t.Errorf("a held lock is acquired by another session: %v", err)
+	}
+	if err = l.Refresh(lock); err != nil {
+		t.Errorf("refresh of a held lock: %v", err)
+	}
+	if err = l.Release(lock); err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Release(lock); !errors.Is(err, ErrNotHeld) {
+		t.Errorf("second release: %v", err)
+	}
+}
+
+func TestPostgresRefreshLost(t *testing.T) {
+	db := openPostgres(t)
+	l := NewPostgresLocker(db, Options{HeartbeatInterval: time.Hour})
+	defer l.Close()
+	lock, err := l.TryAcquire("lockdb_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The session stays alive but no longer holds the lock.
+	_, err = lock.state.conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", advisoryKey(lock.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Refresh(lock); !errors.Is(err, ErrLockLost) {
+		t.Errorf("refresh of a lock the session dropped: %v", err)
+	}
+	select {
+	case <-lock.Lost():
+	default:
+		t.Error("the holder is not told the lock is lost")
+	}
+}
@@ -0,0 +1,236 @@
+package lockdb
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// prometheusBuckets are the upper bounds in seconds of the time histograms
+// and roundTripBuckets those of the round trip histogram.
+var (
+	prometheusBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300}
+	roundTripBuckets  = []float64{1, 2, 3, 4, 5, 6, 8, 10, 15, 20, 30, 50, 100}
+)
+
+// otherLockLabel is the lock label of the names past WithMaxLockLabels.
+const otherLockLabel = "other"
+
+// PrometheusMetrics is a Metrics keeping counters and histograms by lock name
+// in memory, it serves them over HTTP in the Prometheus text exposition
+// format, e.g. http.Handle("/metrics", metrics). Every name is a series of
+// its own which is never dropped, bound them by WithLockLabel or
+// WithMaxLockLabels if names are generated e.g. per user or per job.
+type PrometheusMetrics struct {
+	mu         sync.Mutex
+	label      func(name string) string
+	maxLabels  int
+	labels     map[string]struct{}
+	acquired   map[string]float64
+	timeouts   map[string]float64
+	heartbeats map[string]float64
+	takeovers  map[string]float64
+	wait       map[string]*histogram
+	hold       map[string]*histogram
+	roundTrips map[string]*histogram
+}
+
+var _ Metrics = (*PrometheusMetrics)(nil)
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func (h *histogram) observe(v float64) {
+	for i, le := range h.buckets {
+		if v <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// PrometheusOption customizes a PrometheusMetrics.
+type PrometheusOption func(*PrometheusMetrics)
+
+// WithLockLabel sets the lock label of a name to label(name), e.g. its
+// prefix to group generated names. An empty label drops the lock label, so
+// WithLockLabel(func(string) string { return "" }) sums up all locks.
+func WithLockLabel(label func(name string) string) PrometheusOption {
+	return func(m *PrometheusMetrics) {
+		m.label = label
+	}
+}
+
+// WithMaxLockLabels keeps at most n lock labels, the names seen after n
+// others are counted under the "other" label.
+func WithMaxLockLabels(n int) PrometheusOption {
+	return func(m *PrometheusMetrics) {
+		m.maxLabels = n
+	}
+}
+
+func NewPrometheusMetrics(opts ...PrometheusOption) *PrometheusMetrics {
+	m := &PrometheusMetrics{
+		labels:     make(map[string]struct{}),
+		acquired:   make(map[string]float64),
+		timeouts:   make(map[string]float64),
+		heartbeats: make(map[string]float64),
+		takeovers:  make(map[string]float64),
+		wait:       make(map[string]*histogram),
+		hold:       make(map[string]*histogram),
+		roundTrips: make(map[string]*histogram),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// lockLabel returns the lock label name is counted under, m.mu is held.
+func (m *PrometheusMetrics) lockLabel(name string) string {
+	if m.label != nil {
+		name = m.label(name)
+	}
+	if m.maxLabels <= 0 || name == "" {
+		return name
+	}
+	if _, ok := m.labels[name]; !ok {
+		if len(m.labels) >= m.maxLabels {
+			return otherLockLabel
+		}
+		m.labels[name] = struct{}{}
+	}
+	return name
+}
+
+func (m *PrometheusMetrics) Acquired(name string, wait time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	label := m.lockLabel(name)
+	m.acquired[label]++
+	observe(m.wait, label, prometheusBuckets, wait.Seconds())
+}
+
+func (m *PrometheusMetrics) TimedOut(name string, wait time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	label := m.lockLabel(name)
+	m.timeouts[label]++
+	observe(m.wait, label, prometheusBuckets, wait.Seconds())
+}
+
+func (m *PrometheusMetrics) Released(name string, held time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	observe(m.hold, m.lockLabel(name), prometheusBuckets, held.Seconds())
+}
+
+func (m *PrometheusMetrics) HeartbeatFailed(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.heartbeats[m.lockLabel(name)]++
+}
+
+func (m *PrometheusMetrics) TakenOver(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.takeovers[m.lockLabel(name)]++
+}
+
+func (m *PrometheusMetrics) RoundTrips(name string, n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	observe(m.roundTrips, m.lockLabel(name), roundTripBuckets, float64(n))
+}
+
+func observe(histograms map[string]*histogram, label string, buckets []float64, v float64) {
+	h := histograms[label]
+	if h == nil {
+		h = &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+		histograms[label] = h
+	}
+	h.observe(v)
+}
+
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (m *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	writeCounter(&b, "lockdb_acquisitions_total", "Locks acquired.", m.acquired)
+	writeCounter(&b, "lockdb_timeouts_total", "Lock acquisitions timed out.", m.timeouts)
+	writeHistogram(&b, "lockdb_wait_seconds", "Time waited to acquire locks, timed out ones included.", m.wait)
+	writeHistogram(&b, "lockdb_hold_seconds", "Time locks were held until released.", m.hold)
+	writeCounter(&b, "lockdb_heartbeat_failures_total", "Lock heartbeats failed.", m.heartbeats)
+	writeCounter(&b, "lockdb_takeovers_total", "Expired locks taken over.", m.takeovers)
+	writeHistogram(&b, "lockdb_acquire_round_trips", "Statements sent to the database per acquisition.", m.roundTrips)
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func writeCounter(b *strings.Builder, metric, help string, values map[string]float64) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s counter\n", metric, help, metric)
+	for _, label := range sortedKeys(values) {
+		fmt.Fprintf(b, "%s%s %v\n", metric, labels(label, ""), values[label])
+	}
+}
+
+func writeHistogram(b *strings.Builder, metric, help string, histograms map[string]*histogram) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s histogram\n", metric, help, metric)
+	for _, label := range sortedKeys(histograms) {
+		h := histograms[label]
+		for i, le := range h.buckets {
+			fmt.Fprintf(b, "%s_bucket%s %d\n", metric, labels(label, fmt.Sprintf(`le="%v"`, le)), h.counts[i])
+		}
+		fmt.Fprintf(b, "%s_bucket%s %d\n", metric, labels(label, `le="+Inf"`), h.count)
+		fmt.Fprintf(b, "%s_sum%s %v\n", metric, labels(label, ""), h.sum)
+		fmt.Fprintf(b, "%s_count%s %d\n", metric, labels(label, ""), h.count)
+	}
+}
+
+// labels formats the label set of a series, without the lock label if it is
+// empty and with extra, e.g. le="1", if any.
+func labels(lock, extra string) string {
+	var set []string
+	if lock != "" {
+		set = append(set, "lock="+quoteLabel(lock))
+	}
+	if extra != "" {
+		set = append(set, extra)
+	}
+	if len(set) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(set, ",") + "}"
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// quoteLabel quotes a label value escaping backslash, double quote and line
+// feed as the exposition format requires.
+func quoteLabel(v string) string {
+	v = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+	return `"` + v + `"`
+}
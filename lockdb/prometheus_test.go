@@ -0,0 +1,107 @@
+package lockdb
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func scrape(t *testing.T, m *PrometheusMetrics) string {
+	t.Helper()
+	var b strings.Builder
+	if _, err := m.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestPrometheusMetrics(t *testing.T) {
+	m := NewPrometheusMetrics()
+	m.Acquired("name1", time.Millisecond)
+	m.Acquired("name1", time.Second)
+	m.TimedOut(`a"b`, time.Second)
+	out := scrape(t, m)
+	for _, line := range []string{
+		`lockdb_acquisitions_total{lock="name1"} 2`,
+		`lockdb_timeouts_total{lock="a\"b"} 1`,
+		`lockdb_wait_seconds_bucket{lock="name1",le="0.005"} 1`,
+		`lockdb_wait_seconds_bucket{lock="name1",le="+Inf"} 2`,
+		`lockdb_wait_seconds_count{lock="a\"b"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %s in\n%s", line, out)
+		}
+	}
+}
+
+func TestPrometheusMaxLockLabels(t *testing.T) {
+	m := NewPrometheusMetrics(WithMaxLockLabels(2))
+	for _, name := range []string{"job1", "job2", "job3", "job4", "job1"} {
+		m.Acquired(name, time.Millisecond)
+	}
+	out := scrape(t, m)
+	for _, line := range []string{
+		`lockdb_acquisitions_total{lock="job1"} 2`,
+		`lockdb_acquisitions_total{lock="job2"} 1`,
+		`lockdb_acquisitions_total{lock="other"} 2`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %s in\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, "job3") {
+		t.Errorf("a name past the limit got a label:\n%s", out)
+	}
+}
+
+func TestPrometheusLockLabel(t *testing.T) {
+	m := NewPrometheusMetrics(WithLockLabel(func(string) string { return "" }))
+	m.Acquired("job1", time.Millisecond)
+	m.Acquired("job2", time.Millisecond)
+	out := scrape(t, m)
+	for _, line := range []string{
+		`lockdb_acquisitions_total 2`,
+		`lockdb_wait_seconds_bucket{le="+Inf"} 2`,
+		`lockdb_wait_seconds_sum 0.002`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %s in\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, "lock=") {
+		t.Errorf("aggregated metrics keep the lock label:\n%s", out)
+	}
+}
+
+func TestMetricsCountEveryAcquisition(t *testing.T) {
+	db := openTestDB(t)
+	m := NewPrometheusMetrics(WithLockLabel(func(string) string { return "" }))
+	l := NewDBLocker(db, Options{Metrics: m})
+	memory := NewMemoryLocker(Options{Metrics: m})
+	ctx := context.Background()
+	for _, c := range []struct {
+		locker  Locker
+		acquire func() (*Lock, error)
+	}{
+		{l, func() (*Lock, error) { return l.TryAcquire("name1") }},
+		{l, func() (*Lock, error) { return l.AcquireContext(ctx, "name1") }},
+		{l, func() (*Lock, error) { return l.TryAcquireShared("name1") }},
+		{l, func() (*Lock, error) { return l.AcquireShared(ctx, "name1") }},
+		{l, func() (*Lock, error) { return l.TryAcquirePermit("name1", 1) }},
+		{l, func() (*Lock, error) { return l.AcquirePermit(ctx, "name1", 1) }},
+		{memory, func() (*Lock, error) { return memory.TryAcquire("name1") }},
+		{memory, func() (*Lock, error) { return memory.AcquireContext(ctx, "name1") }},
+	} {
+		lock, err := c.acquire()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = c.locker.Release(lock); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m.acquired[""] != 8 || m.hold[""].count != 8 {
+		t.Errorf("%v acquisitions and %d releases counted, want 8 of both", m.acquired[""], m.hold[""].count)
+	}
+}
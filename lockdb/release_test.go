@@ -0,0 +1,95 @@
+package lockdb
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReleaseStaleHolder(t *testing.T) {
+	db := openTestDB(t)
+	l := NewDBLocker(db, Options{HeartbeatInterval: time.Hour, LeaseTTL: time.Millisecond})
+	stale, err := l.TryAcquire("name1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 5)
+	next, err := l.TryAcquire("name1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = l.Release(stale); !errors.Is(err, ErrNotHeld) {
+		t.Errorf("release of a taken over lock: %v", err)
+	}
+	if _, err = l.Describe("name1"); err != nil {
+		t.Errorf("a stale release deleted the lock of the next holder: %v", err)
+	}
+	l.Release(next)
+}
+
+func TestReleaseConcurrent(t *testing.T) {
+	db := openTestDB(t)
+	l := NewDBLocker(db, Options{})
+	first, err := l.TryAcquire("name1", WithOwner("o"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := l.TryAcquire("name1", WithOwner("o"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	released := 0
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := l.Release(second)
+			if err != nil && !errors.Is(err, ErrNotHeld) {
+				t.Error(err)
+			}
+			if err == nil {
+				mu.Lock()
+				released++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if released != 1 {
+		t.Errorf("a hold is released %d times", released)
+	}
+	lock, err := l.Describe("name1")
+	if err != nil || lock.Holds != 1 {
+		t.Fatalf("holds after releasing one of two: %v, %v", lock, err)
+	}
+	if err = l.Release(first); err != nil {
+		t.Fatal(err)
+	}
+	if err = ReleaseLock(db, first); !errors.Is(err, ErrNotHeld) {
+		t.Errorf("second release: %v", err)
+	}
+}
+
+func TestReleaseRetry(t *testing.T) {
+	db := openTestDB(t)
+	l := NewDBLocker(db, Options{})
+	lock, err := l.TryAcquire("name1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Exec("ALTER TABLE locks RENAME TO locks_away")
+	if err = l.Release(lock); err == nil || errors.Is(err, ErrNotHeld) {
+		t.Fatalf("release without the table: %v", err)
+	}
+	db.Exec("ALTER TABLE locks_away RENAME TO locks")
+	if err = l.Release(lock); err != nil {
+		t.Fatalf("retry of a failed release: %v", err)
+	}
+	if _, err = l.Describe("name1"); !errors.Is(err, ErrLockNotFound) {
+		t.Errorf("lock is left after a retried release: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package lockdb
+
+import (
+	"context"
+	"sync/atomic"
+
+	"gorm.io/gorm"
+)
+
+const roundTripCallback = "lockdb:round_trip"
+
+type roundTripsKey struct{}
+
+// withRoundTrips returns a context counting the statements gorm sends with it.
+func withRoundTrips(ctx context.Context) (context.Context, *int64) {
+	n := new(int64)
+	return context.WithValue(ctx, roundTripsKey{}, n), n
+}
+
+// Instrument makes the lockers on db count the statements of each
+// acquisition into Lock.RoundTrips and Metrics.RoundTrips, other statements
+// are not affected. Migrate calls it, call it yourself if the schema is
+// managed otherwise. gorm does not guard its callbacks, so call it before
+// sharing db among goroutines. Calling it again does nothing.
+func Instrument(db *gorm.DB) error {
+	callbacks := db.Callback()
+	processors := []interface {
+		Get(name string) func(*gorm.DB)
+		Register(name string, fn func(*gorm.DB)) error
+	}{callbacks.Create(), callbacks.Query(), callbacks.Update(), callbacks.Delete(), callbacks.Row(), callbacks.Raw()}
+	for _, p := range processors {
+		if p.Get(roundTripCallback) != nil {
+			continue
+		}
+		if err := p.Register(roundTripCallback, countRoundTrip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countRoundTrips runs acquire with a context counting its statements into
+// the acquired lock.
+func countRoundTrips(ctx context.Context, acquire func(ctx context.Context) (*Lock, error)) (*Lock, error) {
+	ctx, n := withRoundTrips(ctx)
+	lock, err := acquire(ctx)
+	if lock != nil {
+		lock.RoundTrips = atomic.LoadInt64(n)
+	}
+	return lock, err
+}
+
+func countRoundTrip(tx *gorm.DB) {
+	if tx.Statement.Context == nil {
+		return
+	}
+	if n, ok := tx.Statement.Context.Value(roundTripsKey{}).(*int64); ok {
+		atomic.AddInt64(n, 1)
+	}
+}
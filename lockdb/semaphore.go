@@ -0,0 +1,114 @@
+package lockdb
+
+import (
+	"context"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+// Permit is the row of one holder of a counting semaphore. The unique index
+// on Name and Slot makes sure at most limit holders exist for a name while
+// slots are taken from [0, limit).
+type Permit struct {
+	ID          uint
+	Name        string `gorm:"uniqueIndex:idx_permit_slot"`
+	Slot        int    `gorm:"uniqueIndex:idx_permit_slot"`
+	CreateAt    time.Time
+	HeartbeatAt time.Time
+	ExpiresAt   *time.Time
+	Version     string `gorm:"uniqueIndex"`
+	// The holder as on Lock, WithPayload applies but WithOwner does not
+	// since permits are not reentrant.
+	Hostname     string
+	PID          int `gorm:"column:pid"`
+	ProcessStart time.Time
+	Payload      string
+}
+
+// AcquirePermit waits for one of the limit permits of the semaphore name, the
+// returned lock is heartbeated like an exclusive one and released by Release.
+// Permits whose holder let its lease expire are reclaimed.
+func (l *DBLocker) AcquirePermit(ctx context.Context, name string, limit int, opts ...AcquireOption) (*Lock, error) {
+	start := l.opts.Clock.Now()
+	o := newAcquireOptions(opts)
+	lock, err := countRoundTrips(ctx, func(ctx context.Context) (*Lock, error) {
+		return poll(ctx, l.opts, name, func(ctx context.Context) (*Lock, error) {
+			return l.tryAcquirePermit(ctx, name, limit, o)
+		})
+	})
+	observeAcquire(l.opts, name, start, lock, err)
+	return lock, err
+}
+
+func (l *DBLocker) TryAcquirePermit(name string, limit int, opts ...AcquireOption) (*Lock, error) {
+	start := l.opts.Clock.Now()
+	lock, err := countRoundTrips(context.Background(), func(ctx context.Context) (*Lock, error) {
+		return l.tryAcquirePermit(ctx, name, limit, newAcquireOptions(opts))
+	})
+	err = busyError(err)
+	observeAcquire(l.opts, name, start, lock, err)
+	return lock, err
+}
+
+func (l *DBLocker) tryAcquirePermit(ctx context.Context, name string, limit int, o acquireOptions) (*Lock, error) {
+	db := l.db.WithContext(ctx)
+	if err := l.reapPermits(db, name); err != nil {
+		return nil, err
+	}
+
+	var slots []int
+	if err := db.Model(&Permit{}).Where("name=?", name).Pluck("slot", &slots).Error; err != nil {
+		return nil, err
+	}
+	if len(slots) >= limit {
+		return nil, ErrLockBusy
+	}
+	taken := make(map[int]bool, len(slots))
+	for _, slot := range slots {
+		taken[slot] = true
+	}
+
+	for slot := 0; slot < limit; slot++ {
+		if taken[slot] {
+			continue
+		}
+		now := l.opts.Clock.Now()
+		lock := &Lock{Name: name, CreateAt: now, HeartbeatAt: now, ExpiresAt: l.opts.lease(now),
+			Version: uuid.NewV4().String(), kind: kindPermit}
+		o.describe(lock)
+		row := &Permit{Name: name, Slot: slot, CreateAt: now, HeartbeatAt: now, ExpiresAt: lock.ExpiresAt,
+			Version: lock.Version, Hostname: lock.Hostname, PID: lock.PID, ProcessStart: lock.ProcessStart,
+			Payload: lock.Payload}
+		err := db.Create(row).Error
+		if isDuplicateKeyError(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		lock.ID = row.ID
+		if err := l.hold(lock); err != nil {
+			return nil, err
+		}
+		return lock, nil
+	}
+	return nil, ErrLockBusy
+}
+
+// ListPermits returns the current holders of the semaphore name by slot.
+func (l *DBLocker) ListPermits(name string) ([]Permit, error) {
+	if err := l.reapPermits(l.db, name); err != nil {
+		return nil, err
+	}
+	var permits []Permit
+	err := l.db.Where("name=?", name).Order("slot").Find(&permits).Error
+	return permits, err
+}
+
+// reapPermits deletes the permits of name whose lease expired.
+func (l *DBLocker) reapPermits(db *gorm.DB, name string) error {
+	return db.Where("name=? and expires_at<?", name, l.opts.Clock.Now()).Delete(&Permit{}).Error
+}
@@ -0,0 +1,112 @@
+package lockdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPermits(t *testing.T) {
+	db := openTestDB(t)
+	l := NewDBLocker(db, Options{})
+	a, err := l.TryAcquirePermit("name1", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := l.TryAcquirePermit("name1", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = l.TryAcquirePermit("name1", 2); !errors.Is(err, ErrLockBusy) {
+		t.Fatalf("a third permit of 2 is granted: %v", err)
+	}
+	permits, err := l.ListPermits("name1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(permits) != 2 || permits[0].Version != a.Version || permits[1].Version != b.Version {
+		t.Fatalf("permits listed: %+v", permits)
+	}
+
+	l.Release(a)
+	c, err := l.TryAcquirePermit("name1", 2)
+	if err != nil {
+		t.Fatalf("a released permit is not granted again: %v", err)
+	}
+
+	// A holder which let its lease expire loses its permit.
+	db.Model(&Permit{}).Where("version=?", b.Version).Update("expires_at", time.Now().Add(-time.Second))
+	d, err := l.TryAcquirePermit("name1", 2)
+	if err != nil {
+		t.Fatalf("an expired permit is not reclaimed: %v", err)
+	}
+	if err = l.Release(b); !errors.Is(err, ErrNotHeld) {
+		t.Errorf("release of a reclaimed permit: %v", err)
+	}
+	l.Release(c)
+	l.Release(d)
+}
+
+func TestPermitsConcurrent(t *testing.T) {
+	db := openTestDB(t)
+	l := NewDBLocker(db, Options{PollInterval: time.Millisecond * 5})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*20)
+	defer cancel()
+	var mu sync.Mutex
+	var holders, most int
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lock, err := l.AcquirePermit(ctx, "name1", 3)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			if holders++; holders > most {
+				most = holders
+			}
+			mu.Unlock()
+			time.Sleep(time.Millisecond * 20)
+			mu.Lock()
+			holders--
+			mu.Unlock()
+			if err = l.Release(lock); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if most > 3 {
+		t.Errorf("%d holders of 3 permits at once", most)
+	}
+}
+
+func TestPermitHolder(t *testing.T) {
+	db := openTestDB(t)
+	l := NewDBLocker(db, Options{})
+	lock, err := l.TryAcquirePermit("name1", 2, WithPayload(json.RawMessage(`{"job":1}`)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	permits, err := l.ListPermits("name1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(permits) != 1 {
+		t.Fatalf("permits listed: %+v", permits)
+	}
+	p := permits[0]
+	if p.Hostname != hostname || p.PID != os.Getpid() || !p.ProcessStart.Equal(processStart) || p.Payload != `{"job":1}` {
+		t.Errorf("holder of the permit: %+v", p)
+	}
+	if lock.Payload != p.Payload {
+		t.Errorf("payload of the lock: %s", lock.Payload)
+	}
+}
@@ -0,0 +1,148 @@
+package lockdb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"gorm.io/gorm"
+)
+
+// SharedLock is the row of one shared holder, many of them may exist for a
+// name while no exclusive Lock row does.
+type SharedLock struct {
+	ID          uint
+	Name        string `gorm:"index"`
+	CreateAt    time.Time
+	HeartbeatAt time.Time
+	ExpiresAt   *time.Time
+	Version     string `gorm:"uniqueIndex"`
+	// The holder as on Lock, WithPayload applies but WithOwner does not
+	// since shared locks are not reentrant.
+	Hostname     string
+	PID          int `gorm:"column:pid"`
+	ProcessStart time.Time
+	Payload      string
+}
+
+var _ RWLocker = (*DBLocker)(nil)
+
+// AcquireShared waits until no exclusive holder or waiter exists for name and
+// joins the shared holders, the returned lock is heartbeated like an
+// exclusive one and released by Release.
+func (l *DBLocker) AcquireShared(ctx context.Context, name string, opts ...AcquireOption) (*Lock, error) {
+	start := l.opts.Clock.Now()
+	o := newAcquireOptions(opts)
+	lock, err := countRoundTrips(ctx, func(ctx context.Context) (*Lock, error) {
+		return poll(ctx, l.opts, name, func(ctx context.Context) (*Lock, error) {
+			return l.tryAcquireShared(ctx, name, o)
+		})
+	})
+	observeAcquire(l.opts, name, start, lock, err)
+	return lock, err
+}
+
+func (l *DBLocker) TryAcquireShared(name string, opts ...AcquireOption) (*Lock, error) {
+	start := l.opts.Clock.Now()
+	lock, err := countRoundTrips(context.Background(), func(ctx context.Context) (*Lock, error) {
+		return l.tryAcquireShared(ctx, name, newAcquireOptions(opts))
+	})
+	err = busyError(err)
+	observeAcquire(l.opts, name, start, lock, err)
+	return lock, err
+}
+
+func (l *DBLocker) tryAcquireShared(ctx context.Context, name string, o acquireOptions) (*Lock, error) {
+	db := l.db.WithContext(ctx)
+	if err := l.checkExclusive(db, name); err != nil {
+		return nil, err
+	}
+
+	now := l.opts.Clock.Now()
+	lock := &Lock{Name: name, CreateAt: now, HeartbeatAt: now, ExpiresAt: l.opts.lease(now),
+		Version: uuid.NewV4().String(), kind: kindShared}
+	o.describe(lock)
+	row := &SharedLock{Name: name, CreateAt: now, HeartbeatAt: now, ExpiresAt: lock.ExpiresAt, Version: lock.Version,
+		Hostname: lock.Hostname, PID: lock.PID, ProcessStart: lock.ProcessStart, Payload: lock.Payload}
+	if err := db.Create(row).Error; err != nil {
+		return nil, err
+	}
+
+	// An exclusive locker may have come between the check and the insert,
+	// it is preferred so step back.
+	if err := l.checkExclusive(db, name); err != nil {
+		l.db.Delete(&SharedLock{}, row.ID)
+		return nil, err
+	}
+
+	lock.ID = row.ID
+	if err := l.hold(lock); err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// ListShared returns the live shared holders of name.
+func (l *DBLocker) ListShared(name string) ([]SharedLock, error) {
+	var shared []SharedLock
+	err := l.db.Where("name=? and (expires_at is null or expires_at>=?)", name, l.opts.Clock.Now()).
+		Order("id").Find(&shared).Error
+	return shared, err
+}
+
+// checkExclusive returns ErrLockBusy if name is held or waited exclusively,
+// an expired exclusive lock is deleted unless its holder renewed it meanwhile.
+func (l *DBLocker) checkExclusive(db *gorm.DB, name string) error {
+	var lock Lock
+	result := db.Take(&lock, "name=?", name)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if result.Error != nil {
+		return result.Error
+	}
+	if !l.expired(&lock) {
+		return ErrLockBusy
+	}
+	result = db.Where("name=? and version=? and expires_at<?", name, lock.Version, l.opts.Clock.Now()).Delete(&Lock{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrLockBusy
+	}
+	return nil
+}
+
+// waitShared waits for the shared holders of a just preempted exclusive lock
+// to leave.
+func (l *DBLocker) waitShared(ctx context.Context, lock *Lock) error {
+	_, err := poll(ctx, l.opts, lock.Name, func(ctx context.Context) (*Lock, error) {
+		n, err := l.countShared(ctx, lock.Name)
+		if err == nil && n > 0 {
+			err = ErrLockBusy
+		}
+		return nil, err
+	})
+	return err
+}
+
+// countShared counts the live shared holders of name. It only reads, so
+// exclusive lockers do not take the write lock of the database for it, the
+// expired rows are left to ReleaseTimeout and ReapExpired.
+func (l *DBLocker) countShared(ctx context.Context, name string) (int64, error) {
+	var n int64
+	err := l.db.WithContext(ctx).Model(&SharedLock{}).
+		Where("name=? and (expires_at is null or expires_at>=?)", name, l.opts.Clock.Now()).Count(&n).Error
+	return n, err
+}
+
+// reapShared deletes the shared holders of name which have not heartbeated
+// within ttl, nothing is deleted if ttl is not positive.
+func (l *DBLocker) reapShared(db *gorm.DB, name string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return nil
+	}
+	return db.Where("name=? and heartbeat_at<?", name, l.opts.Clock.Now().Add(-ttl)).Delete(&SharedLock{}).Error
+}
@@ -0,0 +1,118 @@
+package lockdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSharedLock(t *testing.T) {
+	db := openTestDB(t)
+	l := NewDBLocker(db, Options{PollInterval: time.Millisecond * 5})
+	r1, err := l.TryAcquireShared("name1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r2, err := l.TryAcquireShared("name1")
+	if err != nil {
+		t.Fatalf("a second reader is refused: %v", err)
+	}
+	if _, err = l.TryAcquire("name1"); !errors.Is(err, ErrLockBusy) {
+		t.Fatalf("a writer took a lock with readers: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	acquired := make(chan *Lock, 1)
+	go func() {
+		w, err := l.AcquireContext(ctx, "name1")
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- w
+	}()
+	// The waiting writer keeps new readers out.
+	for {
+		if _, err = l.Describe("name1"); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err = l.TryAcquireShared("name1"); !errors.Is(err, ErrLockBusy) {
+		t.Fatalf("a reader joined while a writer waits: %v", err)
+	}
+
+	l.Release(r1)
+	select {
+	case <-acquired:
+		t.Fatal("the writer got the lock while a reader holds it")
+	case <-time.After(time.Millisecond * 50):
+	}
+	l.Release(r2)
+	w := <-acquired
+	if w == nil {
+		t.FailNow()
+	}
+	if _, err = l.TryAcquireShared("name1"); !errors.Is(err, ErrLockBusy) {
+		t.Fatalf("a reader joined while a writer holds the lock: %v", err)
+	}
+	l.Release(w)
+	r, err := l.TryAcquireShared("name1")
+	if err != nil {
+		t.Fatalf("a reader is refused after the writer left: %v", err)
+	}
+	l.Release(r)
+}
+
+func TestSharedLockExpires(t *testing.T) {
+	db := openTestDB(t)
+	reader := NewDBLocker(db, Options{HeartbeatInterval: time.Hour, LeaseTTL: time.Millisecond * 10})
+	if _, err := reader.TryAcquireShared("name1"); err != nil {
+		t.Fatal(err)
+	}
+	writer := NewDBLocker(db, Options{LeaseTTL: time.Hour})
+	time.Sleep(time.Millisecond * 20)
+	w, err := writer.TryAcquire("name1")
+	if err != nil {
+		t.Fatalf("a reader whose lease expired keeps out a writer: %v", err)
+	}
+	writer.Release(w)
+
+	// Acquisitions only count the readers, reaping is left to ReapExpired.
+	var n int64
+	db.Model(&SharedLock{}).Count(&n)
+	if n != 1 {
+		t.Fatalf("%d shared rows after an exclusive acquisition", n)
+	}
+	if _, err = writer.ReapExpired(time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	db.Model(&SharedLock{}).Count(&n)
+	if n != 0 {
+		t.Errorf("%d shared rows left after ReapExpired", n)
+	}
+}
+
+func TestSharedHolder(t *testing.T) {
+	db := openTestDB(t)
+	l := NewDBLocker(db, Options{})
+	if _, err := l.TryAcquireShared("name1", WithPayload(json.RawMessage(`{"job":1}`))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.AcquireShared(context.Background(), "name1", WithPayload(json.RawMessage(`{"job":2}`))); err != nil {
+		t.Fatal(err)
+	}
+	shared, err := l.ListShared("name1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shared) != 2 || shared[0].Payload != `{"job":1}` || shared[1].Payload != `{"job":2}` {
+		t.Fatalf("shared holders listed: %+v", shared)
+	}
+	if shared[0].Hostname != hostname || shared[0].PID != os.Getpid() {
+		t.Errorf("holder of the shared lock: %+v", shared[0])
+	}
+}
@@ -0,0 +1,55 @@
+package lockdb
+
+import (
+	"context"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// WithLock runs fn in a transaction while holding the lock name. Just before
+// commit the transaction checks the row still has the version and fencing
+// token of the acquisition, by an update which also keeps a takeover waiting
+// until the commit, so fn's writes are rolled back with an ErrLockLost error
+// if the lock was lost meanwhile. The context of tx is canceled once the lock
+// is found lost.
+func (l *DBLocker) WithLock(ctx context.Context, name string, fn func(tx *gorm.DB) error) error {
+	lock, err := l.AcquireContext(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer l.Release(lock)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-lock.Lost():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return l.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := fn(tx); err != nil {
+			return err
+		}
+
+		select {
+		case <-lock.Lost():
+			return fmt.Errorf("%w: %s", ErrLockLost, name)
+		default:
+		}
+		now := l.opts.Clock.Now()
+		result := tx.Model(&Lock{}).Where("name=? and version=? and token=?", lock.Name, lock.Version, lock.Token).
+			Updates(map[string]interface{}{"heartbeat_at": now, "expires_at": l.opts.lease(now)})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			lock.state.markLost()
+			return fmt.Errorf("%w: %s", ErrLockLost, name)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,92 @@
+package lockdb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// guarded is a row written under a lock by the tests.
+type guarded struct {
+	ID uint
+}
+
+func openGuardedDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := openTestDB(t)
+	if err := db.AutoMigrate(&guarded{}); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func countGuarded(t *testing.T, db *gorm.DB) int64 {
+	t.Helper()
+	var n int64
+	if err := db.Model(&guarded{}).Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestWithLockCommits(t *testing.T) {
+	db := openGuardedDB(t)
+	l := NewDBLocker(db, Options{})
+	err := l.WithLock(context.Background(), "name1", func(tx *gorm.DB) error {
+		return tx.Create(&guarded{}).Error
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countGuarded(t, db); n != 1 {
+		t.Errorf("%d rows committed, want 1", n)
+	}
+	lock, err := l.TryAcquire("name1")
+	if err != nil {
+		t.Fatalf("the lock is not released after WithLock: %v", err)
+	}
+	l.Release(lock)
+}
+
+func TestWithLockRollsBackLostLock(t *testing.T) {
+	for column, changed := range map[string]interface{}{
+		"version": gorm.Expr("version||'x'"),
+		"token":   gorm.Expr("token+1"),
+	} {
+		db := openGuardedDB(t)
+		l := NewDBLocker(db, Options{})
+		err := l.WithLock(context.Background(), "name1", func(tx *gorm.DB) error {
+			if err := tx.Create(&guarded{}).Error; err != nil {
+				return err
+			}
+			// Another holder took the lock over before the commit.
+			return tx.Model(&Lock{}).Where("name=?", "name1").Update(column, changed).Error
+		})
+		if !errors.Is(err, ErrLockLost) {
+			t.Errorf("%s changed: WithLock returned %v", column, err)
+		}
+		if n := countGuarded(t, db); n != 0 {
+			t.Errorf("%s changed: %d rows committed", column, n)
+		}
+	}
+}
+
+func TestWithLockCancelsOnLost(t *testing.T) {
+	db := openGuardedDB(t)
+	// No heartbeat before the lease runs out, so the lock is lost soon.
+	l := NewDBLocker(db, Options{HeartbeatInterval: time.Hour, LeaseTTL: time.Millisecond * 100})
+	err := l.WithLock(context.Background(), "name1", func(tx *gorm.DB) error {
+		select {
+		case <-tx.Statement.Context.Done():
+			return tx.Statement.Context.Err()
+		case <-time.After(time.Second * 5):
+			return errors.New("the context is not canceled")
+		}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("WithLock returned %v", err)
+	}
+}